@@ -0,0 +1,370 @@
+/*
+ * Copyright (C) 2016 George W. Dunlap, Citrix Systems UK Ltd
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation; version 2 of the
+ * License only.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301, USA.
+ */
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	SystemWindowSize = SEC
+	// A worker is starved in a window if its throughput is below
+	// this fraction of its average over the run
+	StarvedFraction = 0.1
+	// A sample interval breaks work conservation if a cpu was idle,
+	// and workers were runnable, for more than this fraction of it
+	ViolationFraction = 0.1
+)
+
+// All the workers of a run together, over one window of controller
+// time
+type SystemWindow struct {
+	// Start of the window, from the point at which all workers
+	// were running (ns)
+	Start int
+	// Sum of the workers' throughput and utilization
+	Tput float64
+	Util float64
+	Starved int
+}
+
+// Linear interpolation of y at x, where xs is increasing
+func interpolate(xs []int64, ys []float64, x int64) float64 {
+	i := sort.Search(len(xs), func(i int) bool { return xs[i] >= x })
+	if i == 0 {
+		return ys[0]
+	}
+	if i == len(xs) {
+		return ys[len(ys)-1]
+	}
+	if xs[i] == x {
+		return ys[i]
+	}
+	f := float64(x - xs[i-1]) / float64(xs[i] - xs[i-1])
+	return ys[i-1] + (ys[i] - ys[i-1]) * f
+}
+
+// Each worker's Now is from its own clock; to line things up across
+// workers, map them onto the controller's clock.  A report can only
+// arrive after it was sent, so the smallest difference between
+// receive time and Now is the best estimate of the offset between the
+// two clocks (plus the smallest delivery delay, which should be
+// similar for all workers).  ok is false if any report has no receive
+// time.
+func (run *BenchmarkRun) clockOffsets() (offsets map[WorkerId]int64, ok bool) {
+	offsets = make(map[WorkerId]int64)
+	for _, r := range run.Results.Raw {
+		// Recorded before receive times were
+		if r.Recv == 0 {
+			return
+		}
+		offset, seen := offsets[r.Id]
+		if !seen || r.Recv - int64(r.Now) < offset {
+			offsets[r.Id] = r.Recv - int64(r.Now)
+		}
+	}
+	ok = true
+	return
+}
+
+func (run *BenchmarkRun) processSystem() {
+	offsets, ok := run.clockOffsets()
+	if !ok {
+		return
+	}
+
+	type Series struct {
+		t []int64
+		kops []float64
+		cpu []float64
+		avgTput float64
+	}
+	var all []Series
+
+	var start, end int64
+	for set := range run.Results.Summary {
+		ws := &run.Results.Summary[set]
+		for id := range ws.Workers {
+			s := &ws.Workers[id]
+			if len(s.Raw) < 2 {
+				continue
+			}
+
+			offset := offsets[WorkerId{Set:set, Id:id}]
+			s.ClockOffset = offset
+
+			var sr Series
+			for _, r := range s.Raw {
+				sr.t = append(sr.t, int64(r.Now) + offset)
+				sr.kops = append(sr.kops, float64(r.Kops))
+				sr.cpu = append(sr.cpu, float64(r.Cputime))
+			}
+			sr.avgTput = s.AvgTput
+
+			if len(all) == 0 || sr.t[0] > start {
+				start = sr.t[0]
+			}
+			if len(all) == 0 || sr.t[len(sr.t)-1] < end {
+				end = sr.t[len(sr.t)-1]
+			}
+			all = append(all, sr)
+		}
+	}
+
+	run.Results.System = nil
+	for w := start; w + SystemWindowSize <= end; w += SystemWindowSize {
+		sw := SystemWindow{Start:int(w - start)}
+		for _, sr := range all {
+			kops := interpolate(sr.t, sr.kops, w + SystemWindowSize) - interpolate(sr.t, sr.kops, w)
+			cpu := interpolate(sr.t, sr.cpu, w + SystemWindowSize) - interpolate(sr.t, sr.cpu, w)
+			tput := kops / (float64(SystemWindowSize) / SEC)
+			sw.Tput += tput
+			sw.Util += cpu / float64(SystemWindowSize)
+			if tput < sr.avgTput * StarvedFraction {
+				sw.Starved++
+			}
+		}
+		run.Results.System = append(run.Results.System, sw)
+	}
+}
+
+// The controller's samples of each worker's cpu time, as series
+// which can be interpolated
+func (run *BenchmarkRun) cpuSeries() (t map[WorkerId][]int64, cpu map[WorkerId][]float64) {
+	t = make(map[WorkerId][]int64)
+	cpu = make(map[WorkerId][]float64)
+	for _, s := range run.Results.CpuSamples {
+		t[s.Id] = append(t[s.Id], s.Now)
+		cpu[s.Id] = append(cpu[s.Id], float64(s.Cputime))
+	}
+	return
+}
+
+func (run *BenchmarkRun) hasRunstate() bool {
+	for i := range run.Results.CpuSamples {
+		if run.Results.CpuSamples[i].Total() > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether any worker's runstate times were estimated by sampling
+// rather than measured
+func (run *BenchmarkRun) runstateEstimated() bool {
+	for i := range run.Results.CpuSamples {
+		if run.Results.CpuSamples[i].Estimated {
+			return true
+		}
+	}
+	return false
+}
+
+const RunstateEstimateNote = "~: estimated from the state of each vcpu when sampled"
+
+// Column names for the runnable wait fraction, marked if estimated
+func (run *BenchmarkRun) rwaitNames() (rwait, rwaitavg string) {
+	if run.runstateEstimated() {
+		return "rwait~", "rwaitav~"
+	}
+	return "rwait", "rwaitavg"
+}
+
+// How much of the time each worker spent runnable but waiting for a
+// cpu, over the run and in each window
+func (run *BenchmarkRun) processRunstate() {
+	if !run.hasRunstate() {
+		return
+	}
+
+	bySample := make(map[WorkerId][]CpuSample)
+	for _, s := range run.Results.CpuSamples {
+		bySample[s.Id] = append(bySample[s.Id], s)
+	}
+
+	for set := range run.Results.Summary {
+		ws := &run.Results.Summary[set]
+		var pooled []float64
+		var total float64
+		count := 0
+		for id := range ws.Workers {
+			ss := bySample[WorkerId{Set:set, Id:id}]
+			if len(ss) < 2 {
+				continue
+			}
+			first, last := ss[0], ss[len(ss)-1]
+			if last.Total() - first.Total() <= 0 {
+				continue
+			}
+
+			var t []int64
+			var runnable, all []float64
+			for _, s := range ss {
+				t = append(t, s.Now)
+				runnable = append(runnable, float64(s.Runnable))
+				all = append(all, float64(s.Total()))
+			}
+
+			var fracs []float64
+			for w := first.Now; w + SystemWindowSize <= last.Now; w += SystemWindowSize {
+				dr := interpolate(t, runnable, w + SystemWindowSize) - interpolate(t, runnable, w)
+				dt := interpolate(t, all, w + SystemWindowSize) - interpolate(t, all, w)
+				if dt > 0 {
+					fracs = append(fracs, dr / dt)
+				}
+			}
+
+			s := &ws.Workers[id]
+			s.RunnableFrac = float64(last.Runnable - first.Runnable) / float64(last.Total() - first.Total())
+			s.RunnableDist = NewDistribution(fracs)
+			pooled = append(pooled, fracs...)
+			total += s.RunnableFrac
+			count++
+		}
+		if count > 0 {
+			ws.AvgRunnableFrac = total / float64(count)
+		}
+		ws.RunnableDist = NewDistribution(pooled)
+	}
+}
+
+// How much of the time the pool's cpus were idle, and how often they
+// were idle while a worker was waiting for a cpu
+func (run *BenchmarkRun) processPool() {
+	ps := run.Results.PcpuSamples
+	n := len(run.Results.PoolCpus)
+	if len(ps) < 2 || n == 0 {
+		return
+	}
+	for i := range ps {
+		if len(ps[i].Idle) != n {
+			return
+		}
+	}
+
+	pool := &PoolSummary{CpuIdleFrac:make([]float64, n)}
+	first, last := &ps[0], &ps[len(ps)-1]
+	wall := float64(last.Now - first.Now)
+	for c := range pool.CpuIdleFrac {
+		pool.CpuIdleFrac[c] = float64(last.Idle[c] - first.Idle[c]) / wall
+		pool.IdleFrac += pool.CpuIdleFrac[c] / float64(n)
+	}
+	run.Results.Pool = pool
+
+	if !run.hasRunstate() {
+		return
+	}
+
+	t := make(map[WorkerId][]int64)
+	runnable := make(map[WorkerId][]float64)
+	for _, s := range run.Results.CpuSamples {
+		t[s.Id] = append(t[s.Id], s.Now)
+		runnable[s.Id] = append(runnable[s.Id], float64(s.Runnable))
+	}
+
+	for i := 1; i < len(ps); i++ {
+		dt := float64(ps[i].Now - ps[i-1].Now)
+		if dt <= 0 {
+			continue
+		}
+		var idle, maxIdle float64
+		for c := 0; c < n; c++ {
+			d := float64(ps[i].Idle[c] - ps[i-1].Idle[c])
+			if d < 0 {
+				d = 0
+			}
+			idle += d
+			maxIdle = math.Max(maxIdle, d)
+		}
+		var waiting float64
+		for id := range t {
+			waiting += interpolate(t[id], runnable[id], ps[i].Now) -
+				interpolate(t[id], runnable[id], ps[i-1].Now)
+		}
+
+		pool.Intervals++
+		if maxIdle > dt * ViolationFraction && waiting > dt * ViolationFraction {
+			pool.Violations++
+			pool.WastedCpu += math.Min(idle, waiting) / SEC
+		}
+	}
+}
+
+func (run *BenchmarkRun) PoolTextReport(level int) {
+	pool := run.Results.Pool
+	if pool == nil {
+		return
+	}
+
+	fmt.Printf("\nPool cpus %v: %.1f%% idle", run.Results.PoolCpus, pool.IdleFrac * 100)
+	if pool.Intervals > 0 {
+		fmt.Printf("; idle while workers were runnable in %d of %d intervals (%.2f cpu-s)",
+			pool.Violations, pool.Intervals, pool.WastedCpu)
+		if run.runstateEstimated() {
+			fmt.Printf(" ~")
+		}
+	}
+	fmt.Printf("\n")
+
+	if level >= 1 {
+		fmt.Printf("%8s %8s\n", "cpu", "idle")
+		for c, cpu := range run.Results.PoolCpus {
+			fmt.Printf("%8d %8.2f\n", cpu, pool.CpuIdleFrac[c])
+		}
+	}
+}
+
+func (run *BenchmarkRun) SystemTextReport(level int) {
+	if len(run.Results.System) == 0 {
+		return
+	}
+
+	var tputs, utils []float64
+	starved := 0
+	maxStarved := 0
+	for _, sw := range run.Results.System {
+		tputs = append(tputs, sw.Tput)
+		utils = append(utils, sw.Util)
+		if sw.Starved > 0 {
+			starved++
+		}
+		if sw.Starved > maxStarved {
+			maxStarved = sw.Starved
+		}
+	}
+
+	fmt.Printf("\nSystem: %d windows of %v, %d with starved workers (at most %d)\n",
+		len(run.Results.System), time.Duration(SystemWindowSize), starved, maxStarved)
+	fmt.Printf("%8s %8s %8s %8s %8s %8s %8s %8s %8s\n", "", "window", "p1", "p5", "p50", "p95", "p99", "iqr", "cov")
+	d := NewDistribution(tputs)
+	d.TextReport("  system", "tput")
+	d = NewDistribution(utils)
+	d.TextReport("  system", "util")
+
+	if level >= 2 {
+		fmt.Printf("\n%8s %8s %8s %8s\n", "start", "tput", "util", "starved")
+		for _, sw := range run.Results.System {
+			fmt.Printf("%8.1f %8.2f %8.2f %8d\n",
+				float64(sw.Start) / SEC, sw.Tput, sw.Util, sw.Starved)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+/*
+ * Copyright (C) 2016 George W. Dunlap, Citrix Systems UK Ltd
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation; version 2 of the
+ * License only.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301, USA.
+ */
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	AnomalyOutlier = "outlier"
+	AnomalyGap = "gap"
+	AnomalyLate = "late"
+	AnomalyMissing = "missing"
+)
+
+// Windows whose throughput is further than this from the median of
+// their set, in robust z-scores (based on the median absolute
+// deviation)
+const AnomalyZ = 3.5
+
+// Gaps between reports longer than this many report intervals
+const AnomalyGapFactor = 1.5
+
+// Workers which started, or stopped, this many report intervals
+// later, or earlier, than most of the others
+const AnomalyLateFactor = 2
+
+// Leave suspect windows out of the summaries (set by -x)
+var ExcludeAnomalies bool
+
+type Anomaly struct {
+	Id WorkerId
+	Kind string
+	// The window ending with this report of the worker, or -1
+	// for the whole worker
+	Window int
+	// Robust z-score for outliers, otherwise seconds (of the gap,
+	// or how late / early the worker was)
+	Value float64
+	// Whether windows were left out of the summaries because of
+	// this
+	Excluded bool
+}
+
+func (a *Anomaly) String() string {
+	switch a.Kind {
+	case AnomalyOutlier:
+		return fmt.Sprintf("window %d throughput z-score %.1f", a.Window, a.Value)
+	case AnomalyGap:
+		return fmt.Sprintf("%.2fs between reports before window %d", a.Value, a.Window)
+	case AnomalyLate:
+		return fmt.Sprintf("first report %.2fs after most workers", a.Value)
+	case AnomalyMissing:
+		if a.Value == 0 {
+			return "no reports"
+		}
+		return fmt.Sprintf("last report %.2fs before most workers", a.Value)
+	}
+	return a.Kind
+}
+
+// Windows to leave out of the summaries, by worker and index of the
+// report ending the window
+type excludedWindows map[WorkerId]map[int]bool
+
+func (x excludedWindows) add(id WorkerId, window int) {
+	if x[id] == nil {
+		x[id] = make(map[int]bool)
+	}
+	x[id][window] = true
+}
+
+// Look for things which make a worker's windows unrepresentative: a
+// window far outside the others of its set, gaps between reports, or
+// a worker which wasn't running for as long as the others.
+func (run *BenchmarkRun) detectAnomalies() (excluded excludedWindows) {
+	run.Results.Anomalies = nil
+	excluded = make(excludedWindows)
+
+	reports := make(map[WorkerId][]WorkerReport)
+	for _, e := range run.Results.Raw {
+		reports[e.Id] = append(reports[e.Id], e)
+	}
+
+	flag := func(a Anomaly) {
+		a.Excluded = ExcludeAnomalies
+		run.Results.Anomalies = append(run.Results.Anomalies, a)
+	}
+
+	// Outliers and gaps
+	for set := range run.WorkerSets {
+		interval := run.WorkerSets[set].Params.ReportInterval()
+
+		var tputs []float64
+		for id := 0; id < run.WorkerSets[set].Count; id++ {
+			r := reports[WorkerId{Set:set, Id:id}]
+			for i := 1; i < len(r); i++ {
+				tputs = append(tputs, Throughput(r[i-1].Now, r[i-1].Kops, r[i].Now, r[i].Kops))
+			}
+		}
+		median := Median(tputs)
+		var dev []float64
+		for _, t := range tputs {
+			dev = append(dev, math.Abs(t - median))
+		}
+		mad := Median(dev)
+
+		for id := 0; id < run.WorkerSets[set].Count; id++ {
+			wid := WorkerId{Set:set, Id:id}
+			r := reports[wid]
+			if len(r) == 0 {
+				flag(Anomaly{Id:wid, Kind:AnomalyMissing, Window:-1})
+				continue
+			}
+			for i := 1; i < len(r); i++ {
+				gap := time.Duration(r[i].Now - r[i-1].Now)
+				if float64(gap) > float64(interval) * AnomalyGapFactor {
+					flag(Anomaly{Id:wid, Kind:AnomalyGap, Window:i, Value:gap.Seconds()})
+					excluded.add(wid, i)
+				}
+
+				if mad == 0 {
+					continue
+				}
+				// 0.6745 makes the MAD comparable to a
+				// standard deviation for normal data
+				z := 0.6745 * (Throughput(r[i-1].Now, r[i-1].Kops, r[i].Now, r[i].Kops) - median) / mad
+				if math.Abs(z) > AnomalyZ {
+					flag(Anomaly{Id:wid, Kind:AnomalyOutlier, Window:i, Value:z})
+					excluded.add(wid, i)
+				}
+			}
+		}
+	}
+
+	// Late and early workers, by when the controller got their
+	// reports (older results don't have that)
+	var firsts, lasts []float64
+	for _, r := range reports {
+		if r[0].Recv == 0 {
+			return
+		}
+		firsts = append(firsts, float64(r[0].Recv))
+		lasts = append(lasts, float64(r[len(r)-1].Recv))
+	}
+	if len(firsts) < 2 {
+		return
+	}
+	medFirst, medLast := Median(firsts), Median(lasts)
+
+	// While a worker wasn't running, the others had the pool to
+	// themselves; leave out everyone's windows from then
+	exclude := func(from int64, to int64) {
+		for wid, r := range reports {
+			for i := 1; i < len(r); i++ {
+				if r[i].Recv > from && r[i-1].Recv < to {
+					excluded.add(wid, i)
+				}
+			}
+		}
+	}
+	for set := range run.WorkerSets {
+		interval := float64(run.WorkerSets[set].Params.ReportInterval())
+		for id := 0; id < run.WorkerSets[set].Count; id++ {
+			wid := WorkerId{Set:set, Id:id}
+			r := reports[wid]
+			if len(r) == 0 {
+				continue
+			}
+			first, last := r[0].Recv, r[len(r)-1].Recv
+			if late := float64(first) - medFirst; late > interval * AnomalyLateFactor {
+				flag(Anomaly{Id:wid, Kind:AnomalyLate, Window:-1, Value:late / SEC})
+				exclude(math.MinInt64, first)
+			}
+			if early := medLast - float64(last); early > interval * AnomalyLateFactor {
+				flag(Anomaly{Id:wid, Kind:AnomalyMissing, Window:-1, Value:early / SEC})
+				exclude(last, math.MaxInt64)
+			}
+		}
+	}
+	return
+}
+
+func (run *BenchmarkRun) AnomalyTextReport(level int) {
+	if len(run.Results.Anomalies) == 0 {
+		return
+	}
+
+	counts := make(map[string]int)
+	excluded := 0
+	for i := range run.Results.Anomalies {
+		a := &run.Results.Anomalies[i]
+		counts[a.Kind]++
+		if a.Excluded {
+			excluded++
+		}
+	}
+	fmt.Printf("\nAnomalies:")
+	for _, kind := range []string{AnomalyOutlier, AnomalyGap, AnomalyLate, AnomalyMissing} {
+		if counts[kind] > 0 {
+			fmt.Printf(" %d %s", counts[kind], kind)
+		}
+	}
+	if excluded > 0 {
+		fmt.Printf(" (excluded)")
+	}
+	fmt.Printf("\n")
+
+	for i := range run.Results.Anomalies {
+		a := &run.Results.Anomalies[i]
+		// Outliers can be numerous; list them with -v 1
+		if a.Kind == AnomalyOutlier && level < 1 {
+			continue
+		}
+		fmt.Printf("  %2d:%2d %8s %s\n", a.Id.Set, a.Id.Id, a.Kind, a.String())
+	}
+}
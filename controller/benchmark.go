@@ -25,6 +25,9 @@ import (
 	"encoding/json"
 	"math"
 	"time"
+	"sort"
+	"strings"
+	"strconv"
 )
 
 type WorkerId struct {
@@ -42,6 +45,14 @@ type WorkerReport struct {
 	Kops int
 	MaxDelta int
 	Cputime time.Duration
+	// Only reported by paired (pingpong) workers
+	Msgs int         `json:",omitempty"`
+	RttTotal int     `json:",omitempty"`
+	RttMin int       `json:",omitempty"`
+	RttMax int       `json:",omitempty"`
+	// Controller clock when the report was received (ns since
+	// the epoch)
+	Recv int64       `json:",omitempty"`
 }
 
 type WorkerParams struct {
@@ -56,6 +67,57 @@ func (l *WorkerParams) SetkHZ(kHZ uint64) {
 	}
 }
 
+// Work amounts can be given in microseconds of burn ("70us") rather
+// than kops; convert them to kops using the calibrated rate.
+func (l *WorkerParams) ResolveUnits(nsPerKop float64) (err error) {
+	args := make([]string, len(l.Args))
+	copy(args, l.Args)
+
+	for i := 0; i < len(args); i++ {
+		var nargs int
+		switch args[i] {
+		case "kHZ", "report_interval", "pingpong":
+			nargs = 1
+		case "burnwait":
+			nargs = 2
+		case "peer":
+			nargs = 3
+		}
+		if (args[i] == "burnwait" || args[i] == "pingpong") &&
+			i+1 < len(args) && strings.HasSuffix(args[i+1], "us") {
+			var us float64
+			us, err = strconv.ParseFloat(strings.TrimSuffix(args[i+1], "us"), 64)
+			if err != nil {
+				return
+			}
+			if nsPerKop == 0 {
+				err = fmt.Errorf("Can't convert %s to kops without calibration", args[i+1])
+				return
+			}
+			kops := int(us * USEC / nsPerKop + 0.5)
+			if kops < 1 {
+				kops = 1
+			}
+			args[i+1] = fmt.Sprintf("%d", kops)
+		}
+		i += nargs
+	}
+	l.Args = args
+	return
+}
+
+// How often the worker reports (1s unless report_interval is given)
+func (l *WorkerParams) ReportInterval() time.Duration {
+	for i := 0; i + 1 < len(l.Args); i++ {
+		if l.Args[i] == "report_interval" {
+			if ms, err := strconv.Atoi(l.Args[i+1]); err == nil && ms > 0 {
+				return time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+	return time.Second
+}
+
 type WorkerConfig struct {
 	Pool string
 	SoftAffinity string
@@ -72,6 +134,41 @@ type WorkerSet struct {
 	Params WorkerParams
 	Config WorkerConfig
 	Count int
+	// If set, worker N of this set will ping-pong with worker N
+	// of set PairWith; this set initiates.
+	PairWith *int     `json:",omitempty"`
+	// Name of the preset this set was made from, if any
+	Preset string      `json:",omitempty"`
+	// Index of the baseline run for the preset
+	BaselineRun *int   `json:",omitempty"`
+}
+
+// Check that pairings refer to sets which exist, have the same
+// number of workers, and are only paired once.
+func (run *BenchmarkRun) CheckPairs() (err error) {
+	paired := make(map[int]bool)
+	for set := range run.WorkerSets {
+		if run.WorkerSets[set].PairWith == nil {
+			continue
+		}
+		peer := *run.WorkerSets[set].PairWith
+		if peer < 0 || peer >= len(run.WorkerSets) || peer == set {
+			err = fmt.Errorf("Set %d: invalid PairWith %d", set, peer)
+			return
+		}
+		if run.WorkerSets[peer].Count != run.WorkerSets[set].Count {
+			err = fmt.Errorf("Set %d: Count %d doesn't match set %d Count %d",
+				set, run.WorkerSets[set].Count, peer, run.WorkerSets[peer].Count)
+			return
+		}
+		if paired[set] || paired[peer] {
+			err = fmt.Errorf("Set %d: sets can only be paired once", set)
+			return
+		}
+		paired[set] = true
+		paired[peer] = true
+	}
+	return
 }
 
 const (
@@ -96,26 +193,62 @@ func Utilization(lt int, lct time.Duration, t int, ct time.Duration) (util float
 type MinMax struct {
 	Min float64
 	Max float64
+	valid bool
 }
 
 func (mm *MinMax) Update(x float64) {
-	if x > mm.Max {
+	if x > mm.Max || !mm.valid {
 		mm.Max = x
 	}
-	if x < mm.Min || mm.Min == 0 {
+	if x < mm.Min || !mm.valid {
 		mm.Min = x
 	}
+	mm.valid = true
 }
 
 type WorkerSummary struct {
 	Raw []WorkerReport
 	MinMaxTput MinMax
 	MinMaxUtil MinMax
+	// Of the per-window throughput and utilization
+	TputDist Distribution
+	UtilDist Distribution
 	TotalTput int
 	TotalTime time.Duration
 	TotalCputime time.Duration
 	AvgTput float64
 	AvgUtil float64
+	// AvgTput as a fraction of the calibrated uncontended rate
+	RelTput float64   `json:",omitempty"`
+	// Max-min fair share of the pool, and how far AvgUtil is
+	// from it (as a fraction of the fair share)
+	FairShare float64 `json:",omitempty"`
+	FairDev float64   `json:",omitempty"`
+	// Throughput expected from the baseline and the fair share,
+	// and AvgTput as a fraction of it
+	ExpectedTput float64 `json:",omitempty"`
+	TputRatio float64    `json:",omitempty"`
+	// Paired workers only
+	TotalMsgs int     `json:",omitempty"`
+	MsgRate float64   `json:",omitempty"`
+	AvgRtt float64    `json:",omitempty"`
+	MinMaxRtt MinMax
+	// Estimated controller clock minus the worker's clock (ns)
+	ClockOffset int64 `json:",omitempty"`
+	// Fraction of the time spent runnable but not running, over
+	// the run and in each window
+	RunnableFrac float64 `json:",omitempty"`
+	RunnableDist Distribution
+}
+
+// Throughput and utilization of each report window
+func (s *WorkerSummary) Windows() (tputs []float64, utils []float64) {
+	for i := 1; i < len(s.Raw); i++ {
+		l, e := &s.Raw[i-1], &s.Raw[i]
+		tputs = append(tputs, Throughput(l.Now, l.Kops, e.Now, e.Kops))
+		utils = append(utils, Utilization(l.Now, l.Cputime, e.Now, e.Cputime))
+	}
+	return
 }
 
 type WorkerSetSummary struct {
@@ -125,17 +258,210 @@ type WorkerSetSummary struct {
 	MinMaxTput    MinMax
 	MinMaxAvgTput MinMax
 	AvgStdDevTput float64
+	RelTput       float64 `json:",omitempty"`
 
 	TotalUtil     float64
 	MinMaxUtil    MinMax
 	MinMaxAvgUtil MinMax
 	AvgAvgUtil    float64
 	AvgStdDevUtil float64
+
+	// Of the windows of all the workers in the set
+	TputDist      Distribution
+	UtilDist      Distribution
+
+	// Runnable wait fraction, averaged over the workers, and of
+	// the windows of all the workers
+	AvgRunnableFrac float64 `json:",omitempty"`
+	RunnableDist  Distribution
+
+	FairShare     float64 `json:",omitempty"`
+	AvgFairDev    float64 `json:",omitempty"`
+	MaxFairDev    float64 `json:",omitempty"`
+	JainIndex     float64 `json:",omitempty"`
+
+	// Throughput relative to the baseline, what it should have
+	// been given the fair share, and the ratio of actual to
+	// expected throughput
+	ExpectedTput  float64 `json:",omitempty"`
+	Degradation   float64 `json:",omitempty"`
+	ExpectedDegradation float64 `json:",omitempty"`
+	TputRatio     float64 `json:",omitempty"`
+}
+
+// What each worker said about itself, and what went wrong on the
+// way; see protocol.go.  Missing counts reports skipped in the
+// sequence, including ones dropped for bad checksums.
+type WorkerProtocol struct {
+	Id WorkerId
+	Version int
+	Caps []string      `json:",omitempty"`
+	KHZ uint64         `json:"kHZ"`
+	Reports int
+	Noise int          `json:",omitempty"`
+	Malformed int      `json:",omitempty"`
+	BadChecksum int    `json:",omitempty"`
+	Gaps int           `json:",omitempty"`
+	Missing int        `json:",omitempty"`
+	Error string       `json:",omitempty"`
+}
+
+func (p *WorkerProtocol) Problems() (s string) {
+	add := func(t string) {
+		if s != "" {
+			s += ", "
+		}
+		s += t
+	}
+	if p.Error != "" {
+		add(p.Error)
+	}
+	if p.Version == 0 {
+		add("no hello")
+	}
+	if p.Malformed > 0 {
+		add(fmt.Sprintf("%d malformed", p.Malformed))
+	}
+	if p.BadChecksum > 0 {
+		add(fmt.Sprintf("%d bad checksums", p.BadChecksum))
+	}
+	if p.Gaps > 0 {
+		add(fmt.Sprintf("%d gaps (%d reports missing)", p.Gaps, p.Missing))
+	}
+	return
 }
 
 type BenchmarkRunData struct {
+	// TSC frequency given to the workers, and how we got it
+	KHZ uint64               `json:"kHZ,omitempty"`
+	KHZMethod string         `json:"kHZMethod,omitempty"`
 	Raw []WorkerReport       `json:",omitempty"`
+	Protocol []WorkerProtocol `json:",omitempty"`
 	Summary []WorkerSetSummary  `json:",omitempty"`
+	// Across all workers in the run
+	JainIndex float64        `json:",omitempty"`
+	// All workers together, in windows aligned to the controller's
+	// clock
+	System []SystemWindow    `json:",omitempty"`
+	// Cpu time of each worker, sampled by the controller
+	CpuSamples []CpuSample   `json:",omitempty"`
+	// The physical cpus the workers ran on, their idle time
+	// sampled by the controller, and a summary
+	PoolCpus []int           `json:",omitempty"`
+	PcpuSamples []PcpuSample `json:",omitempty"`
+	Pool *PoolSummary        `json:",omitempty"`
+	// Suspect windows and workers
+	Anomalies []Anomaly      `json:",omitempty"`
+	// Estimated hypervisor overhead; needs the calibration, so
+	// filled in by the plan
+	Overhead *OverheadSummary `json:",omitempty"`
+	// Where the run was done
+	Host *HostInfo           `json:",omitempty"`
+	// Why the run stopped (see StopReason*)
+	StopReason string        `json:",omitempty"`
+}
+
+// Captured at the start of each run.  Fields which couldn't be found
+// out are left empty.
+type HostInfo struct {
+	Hostname string
+	ControllerVersion string
+	CpuModel string
+	Cpus int
+	ThreadsPerCore int       `json:",omitempty"`
+	CoresPerSocket int       `json:",omitempty"`
+	Nodes int                `json:",omitempty"`
+	MemoryMB uint64          `json:",omitempty"`
+	XenVersion string        `json:",omitempty"`
+	XenChangeset string      `json:",omitempty"`
+	XenCommandline string    `json:",omitempty"`
+	Dom0Vcpus int            `json:",omitempty"`
+	Pool string              `json:",omitempty"`
+	PoolScheduler string     `json:",omitempty"`
+	PoolCpus []int           `json:",omitempty"`
+	// Scheduler parameters of the pool, by name
+	SchedParams map[string]int `json:",omitempty"`
+	WorkerImage string
+	WorkerSha256 string
+	KHZ uint64               `json:"kHZ,omitempty"`
+	KHZMethod string         `json:"kHZMethod,omitempty"`
+}
+
+// Enough to tell runs done on different hosts (or builds) apart
+func (h *HostInfo) Identity() string {
+	return fmt.Sprintf("%s / %s / Xen %s %s", h.Hostname, h.CpuModel, h.XenVersion, h.XenChangeset)
+}
+
+func (h *HostInfo) TextReport(level int) {
+	fmt.Printf("Host: %s\n", h.Identity())
+	if level < 1 {
+		return
+	}
+	fmt.Printf("  controller %s, worker %s (sha256 %s)\n",
+		h.ControllerVersion, h.WorkerImage, h.WorkerSha256)
+	fmt.Printf("  %d cpus (%d threads/core, %d cores/socket, %d nodes), %d MB\n",
+		h.Cpus, h.ThreadsPerCore, h.CoresPerSocket, h.Nodes, h.MemoryMB)
+	if h.XenCommandline != "" {
+		fmt.Printf("  Xen command line: %s\n", h.XenCommandline)
+	}
+	if h.Dom0Vcpus > 0 {
+		fmt.Printf("  dom0 vcpus: %d\n", h.Dom0Vcpus)
+	}
+	if h.Pool != "" {
+		fmt.Printf("  pool %s: %s on cpus %v", h.Pool, h.PoolScheduler, h.PoolCpus)
+		var names []string
+		for name := range h.SchedParams {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Printf(" %s=%d", name, h.SchedParams[name])
+		}
+		fmt.Printf("\n")
+	}
+}
+
+type PcpuSample struct {
+	// Controller clock (ns since the epoch)
+	Now int64
+	// Total idle time of each of PoolCpus
+	Idle []time.Duration
+}
+
+type PoolSummary struct {
+	// Fraction of the time the pool's cpus were idle, together
+	// and each of PoolCpus
+	IdleFrac float64
+	CpuIdleFrac []float64
+	// Sample intervals in which a cpu was idle while a worker was
+	// runnable, and the cpu time (s) lost that way
+	Intervals int
+	Violations int
+	WastedCpu float64
+}
+
+type CpuSample struct {
+	Id WorkerId
+	// Controller clock (ns since the epoch)
+	Now int64
+	Cputime time.Duration
+	Runstate
+}
+
+// Time spent in each state since the first sample.  Xen doesn't
+// give dom0 a vcpu's runstate times, only its state right now, so for
+// Xen workers these are estimated from the state at each sample, and
+// marked as such.
+type Runstate struct {
+	Running time.Duration  `json:",omitempty"`
+	Runnable time.Duration `json:",omitempty"`
+	Blocked time.Duration  `json:",omitempty"`
+	Offline time.Duration  `json:",omitempty"`
+	Estimated bool         `json:",omitempty"`
+}
+
+func (rs Runstate) Total() time.Duration {
+	return rs.Running + rs.Runnable + rs.Blocked + rs.Offline
 }
 
 type RunConfig struct {
@@ -143,6 +469,23 @@ type RunConfig struct {
 	Pool string
 	Cpus []int
 	NumaDisable *bool `json:",omitempty"`
+	// Override the detected TSC frequency
+	KHZ uint64        `json:"kHZ,omitempty"`
+	// Stop runs once throughput has settled, rather than after
+	// RuntimeSeconds
+	Adaptive *AdaptiveConfig `json:",omitempty"`
+}
+
+type AdaptiveConfig struct {
+	// Windows ending before this aren't looked at
+	WarmupSeconds int
+	// Keep going until this if throughput hasn't settled
+	// (RuntimeSeconds if not set)
+	MaxSeconds int      `json:",omitempty"`
+	// Settled when the 95% confidence interval of every worker's
+	// mean window throughput is narrower than this fraction of
+	// the mean (0.05 if not set)
+	CIWidth float64     `json:",omitempty"`
 }
 
 // Propagate unset values from a higher level
@@ -159,10 +502,20 @@ func (l *RunConfig) PropagateFrom(g RunConfig) {
 	if l.NumaDisable == nil {
 		l.NumaDisable = g.NumaDisable
 	}
+	if l.KHZ == 0 {
+		l.KHZ = g.KHZ
+	}
+	if l.Adaptive == nil {
+		l.Adaptive = g.Adaptive
+	}
 }
 
 type BenchmarkRun struct {
 	Label string
+	// Each preset run by itself, for comparison
+	Baseline bool     `json:",omitempty"`
+	// Which repetition of this configuration this is
+	Repeat int        `json:",omitempty"`
 	WorkerSets []WorkerSet
 	WorkerConfig
 	RunConfig
@@ -171,8 +524,24 @@ type BenchmarkRun struct {
 	Results BenchmarkRunData 
 }
 
+// Each preset run alone: throughput, ns of cpu per kop, and the
+// fraction of the time it wants to run
+type PresetCalibration struct {
+	Tput float64
+	NsPerKop float64
+	DutyCycle float64
+}
+
+// NsPerKop is measured with a worker which does nothing but burn, and
+// is used to convert presets given in microseconds.
+type PlanCalibration struct {
+	NsPerKop float64
+	Presets map[string]PresetCalibration `json:",omitempty"`
+}
+
 type BenchmarkPlan struct {
 	Input *PlanInput     `json:",omitempty"`
+	Calibration *PlanCalibration `json:",omitempty"`
 	filename string      `json:",omitempty"`
 	WorkerType int       `json:",omitempty"`
 	// Global options for workers that will be over-ridden by Run
@@ -205,11 +574,34 @@ func (run *BenchmarkRun) Process() (err error) {
 		lastTime int
 		lastKops int
 		lastCputime time.Duration
+		startMsgs int
+		startRtt int
+		lastMsgs int
+		lastRtt int
+		// Sums over the windows not left out with -x
+		keptTime int
+		keptKops int
+		keptCputime time.Duration
+		keptMsgs int
+		keptRtt int
+		tputs []float64
+		utils []float64
 	}
 	
 	data := make(map[WorkerId]*Data)
+	setTputs := make([][]float64, len(run.WorkerSets))
+	setUtils := make([][]float64, len(run.WorkerSets))
+
+	// Use the controller's regular samples of cpu time, rather than
+	// the ones taken whenever a report happened to arrive, if we
+	// can line them up with the reports
+	offsets, aligned := run.clockOffsets()
+	sampleTimes, samples := run.cpuSeries()
+
+	excluded := run.detectAnomalies()
 
 	// FIXME: Filter out results which started before all have started
+	// (only done for workers flagged as late, and only with -x)
 	for i := range run.Results.Raw {
 		e := run.Results.Raw[i]
 
@@ -232,6 +624,11 @@ func (run *BenchmarkRun) Process() (err error) {
 
 		s := &ws.Workers[e.Id.Id]
 
+		if aligned && len(sampleTimes[e.Id]) >= 2 {
+			e.Cputime = time.Duration(interpolate(sampleTimes[e.Id], samples[e.Id],
+				int64(e.Now) + offsets[e.Id]))
+		}
+
 		s.Raw = append(s.Raw, e)
 		
 		d := data[e.Id]
@@ -243,7 +640,17 @@ func (run *BenchmarkRun) Process() (err error) {
 		if d.startTime == 0 {
 			d.startTime = e.Now
 			d.startCputime = e.Cputime
+			d.startMsgs = e.Msgs
+			d.startRtt = e.RttTotal
+		} else if ExcludeAnomalies && excluded[e.Id][len(s.Raw)-1] {
+			// Leave the window out
 		} else {
+			d.keptTime += e.Now - d.lastTime
+			d.keptKops += e.Kops - d.lastKops
+			d.keptCputime += e.Cputime - d.lastCputime
+			d.keptMsgs += e.Msgs - d.lastMsgs
+			d.keptRtt += e.RttTotal - d.lastRtt
+
 			tput := Throughput(d.lastTime, d.lastKops, e.Now, e.Kops)
 			util := Utilization(d.lastTime, d.lastCputime, e.Now, e.Cputime)
 
@@ -251,10 +658,22 @@ func (run *BenchmarkRun) Process() (err error) {
 			s.MinMaxUtil.Update(util)
 			ws.MinMaxTput.Update(tput)
 			ws.MinMaxUtil.Update(util)
+
+			d.tputs = append(d.tputs, tput)
+			d.utils = append(d.utils, util)
+			setTputs[e.Id.Set] = append(setTputs[e.Id.Set], tput)
+			setUtils[e.Id.Set] = append(setUtils[e.Id.Set], util)
+
+			if e.RttMax > 0 {
+				s.MinMaxRtt.Update(float64(e.RttMin))
+				s.MinMaxRtt.Update(float64(e.RttMax))
+			}
 		}
 		d.lastTime = e.Now
 		d.lastKops = e.Kops
 		d.lastCputime = e.Cputime
+		d.lastMsgs = e.Msgs
+		d.lastRtt = e.RttTotal
 	}
 
 	for Id, d := range data {
@@ -268,10 +687,48 @@ func (run *BenchmarkRun) Process() (err error) {
 		s.AvgTput = Throughput(d.startTime, 0, d.lastTime, d.lastKops)
 		s.AvgUtil = Utilization(d.startTime, d.startCputime, d.lastTime, d.lastCputime)
 
+		s.TotalMsgs = d.lastMsgs - d.startMsgs
+		if s.TotalMsgs > 0 {
+			s.MsgRate = Throughput(d.startTime, d.startMsgs, d.lastTime, d.lastMsgs)
+			s.AvgRtt = float64(d.lastRtt - d.startRtt) / float64(s.TotalMsgs)
+		}
+
+		// With -x, everything is over the windows which were
+		// kept, so that the suspect periods don't count at all
+		if ExcludeAnomalies {
+			s.TotalTput = d.keptKops
+			s.TotalTime = time.Duration(d.keptTime)
+			s.TotalCputime = d.keptCputime
+			s.AvgTput, s.AvgUtil = 0, 0
+			s.TotalMsgs = d.keptMsgs
+			s.MsgRate, s.AvgRtt = 0, 0
+			if d.keptTime > 0 {
+				s.AvgTput = Throughput(0, 0, d.keptTime, d.keptKops)
+				s.AvgUtil = Utilization(0, 0, d.keptTime, d.keptCputime)
+				if s.TotalMsgs > 0 {
+					s.MsgRate = Throughput(0, 0, d.keptTime, d.keptMsgs)
+					s.AvgRtt = float64(d.keptRtt) / float64(s.TotalMsgs)
+				}
+			}
+		}
+
 		ws.MinMaxAvgTput.Update(s.AvgTput)
 		ws.MinMaxAvgUtil.Update(s.AvgUtil)
+
+		s.TputDist = NewDistribution(d.tputs)
+		s.UtilDist = NewDistribution(d.utils)
 	}
 
+	for set := range run.Results.Summary {
+		ws := &run.Results.Summary[set]
+		ws.TputDist = NewDistribution(setTputs[set])
+		ws.UtilDist = NewDistribution(setUtils[set])
+	}
+
+	run.processSystem()
+	run.processRunstate()
+	run.processPool()
+
 	// Calculate the average-of-averages for each set
 	for set := range run.Results.Summary {
 		ws := &run.Results.Summary[set]
@@ -329,6 +786,16 @@ func (run *BenchmarkRun) TextReport(level int) (err error) {
 
 	fmt.Printf("== RUN %s ==\n", run.Label)
 
+	if run.Results.Host != nil {
+		run.Results.Host.TextReport(level)
+	}
+	if run.Results.KHZ != 0 {
+		fmt.Printf("Cpu kHZ: %d (%s)\n", run.Results.KHZ, run.Results.KHZMethod)
+	}
+	if run.Results.StopReason != "" {
+		fmt.Printf("Stopped: %s\n", run.Results.StopReason)
+	}
+
 	for set := range run.WorkerSets {
 		ws := &run.WorkerSets[set]
 		params := ""
@@ -338,27 +805,145 @@ func (run *BenchmarkRun) TextReport(level int) (err error) {
 		fmt.Printf("Set %d: %s\n", set, params)
 	}
 
-	fmt.Printf("\n%8s %8s %8s %8s %8s %8s %8s %8s %8s %8s %8s %8s %8s %8s %8s\n", "set", "ttotal", "tavgavg", "tstdev", "tavgmax", "tavgmin", "ttotmax", "ttotmin", "utotal", "uavgavg", "ustdev", "uavgmax", "uavgmin", "utotmax", "utotmin")
+	// Only show throughput relative to the uncontended rate if
+	// there's a calibration to compare against
+	showRel := false
+	for set := range run.Results.Summary {
+		if run.Results.Summary[set].RelTput > 0 {
+			showRel = true
+		}
+	}
+	showWait := run.hasRunstate()
+	rwait, rwaitavg := run.rwaitNames()
+
+	fmt.Printf("\n%8s %8s %8s %8s %8s %8s %8s %8s %8s %8s %8s %8s %8s %8s %8s", "set", "ttotal", "tavgavg", "tstdev", "tavgmax", "tavgmin", "ttotmax", "ttotmin", "utotal", "uavgavg", "ustdev", "uavgmax", "uavgmin", "utotmax", "utotmin")
+	if showRel {
+		fmt.Printf(" %8s", "trel")
+	}
+	if showWait {
+		fmt.Printf(" %8s", rwaitavg)
+	}
+	fmt.Printf("\n")
 	for set := range run.WorkerSets {
 		ws := &run.Results.Summary[set]
-		fmt.Printf("%8d %8.2f %8.2f %8.2f %8.2f %8.2f %8.2f %8.2f %8.2f %8.2f %8.2f %8.2f %8.2f %8.2f %8.2f\n",
+		fmt.Printf("%8d %8.2f %8.2f %8.2f %8.2f %8.2f %8.2f %8.2f %8.2f %8.2f %8.2f %8.2f %8.2f %8.2f %8.2f",
 			set,
 			ws.TotalTput, ws.AvgAvgTput, ws.AvgStdDevTput, ws.MinMaxAvgTput.Max,
 			ws.MinMaxAvgTput.Min, ws.MinMaxTput.Max, ws.MinMaxTput.Min,
 			ws.TotalUtil, ws.AvgAvgUtil, ws.AvgStdDevUtil, ws.MinMaxAvgUtil.Max,
 			ws.MinMaxAvgUtil.Min, ws.MinMaxUtil.Max, ws.MinMaxUtil.Min)
+		if showRel {
+			fmt.Printf(" %8.2f", ws.RelTput)
+		}
+		if showWait {
+			fmt.Printf(" %8.2f", ws.AvgRunnableFrac)
+		}
+		fmt.Printf("\n")
+	}
+	if showWait && run.runstateEstimated() {
+		fmt.Printf("%s\n", RunstateEstimateNote)
+	}
+
+	// Latencies are measured by the initiating set, in usec;
+	// round trips / sec is the same for both sides.
+	printedPairs := false
+	for set := range run.WorkerSets {
+		if run.WorkerSets[set].PairWith == nil {
+			continue
+		}
+		peer := *run.WorkerSets[set].PairWith
+		if ! printedPairs {
+			fmt.Printf("\n%11s %8s %8s %8s %8s\n", "pair", "rtrips/s", "rttavg", "rttmin", "rttmax")
+			printedPairs = true
+		}
+		for id := range run.Results.Summary[set].Workers {
+			s := &run.Results.Summary[set].Workers[id]
+			fmt.Printf("%2d:%2d-%2d:%2d %8.2f %8.2f %8.2f %8.2f\n",
+				set, id, peer, id,
+				s.MsgRate, s.AvgRtt / USEC,
+				s.MinMaxRtt.Min / USEC, s.MinMaxRtt.Max / USEC)
+		}
+	}
+
+	if run.Results.JainIndex > 0 {
+		fmt.Printf("\n%8s %8s %8s %8s %8s %8s %8s %8s %8s\n", "set", "fshare", "uavgavg", "fdevavg", "fdevmax", "jain", "tdegr", "texpdegr", "tratio")
+		for set := range run.Results.Summary {
+			ws := &run.Results.Summary[set]
+			fmt.Printf("%8d %8.2f %8.2f %8.2f %8.2f %8.2f %8.2f %8.2f %8.2f\n",
+				set, ws.FairShare, ws.AvgAvgUtil, ws.AvgFairDev, ws.MaxFairDev, ws.JainIndex,
+				ws.Degradation, ws.ExpectedDegradation, ws.TputRatio)
+		}
+		fmt.Printf("Jain's fairness index (all workers): %.3f\n", run.Results.JainIndex)
+	}
+
+	run.PoolTextReport(level)
+	run.AnomalyTextReport(level)
+
+	printedProtocol := false
+	for i := range run.Results.Protocol {
+		p := &run.Results.Protocol[i]
+		if problems := p.Problems(); problems != "" {
+			if ! printedProtocol {
+				fmt.Printf("\n")
+				printedProtocol = true
+			}
+			fmt.Printf("WARNING: Worker %v protocol: %s\n", p.Id, problems)
+		}
+	}
+
+	if level >= 1 {
+		fmt.Printf("\n%8s %8s %8s %8s %8s %8s %8s %8s %8s\n", "set", "window", "p1", "p5", "p50", "p95", "p99", "iqr", "cov")
+		for set := range run.Results.Summary {
+			ws := &run.Results.Summary[set]
+			ws.TputDist.TextReport(fmt.Sprintf("%8d", set), "tput")
+			ws.UtilDist.TextReport(fmt.Sprintf("%8d", set), "util")
+			if showWait {
+				ws.RunnableDist.TextReport(fmt.Sprintf("%8d", set), rwait)
+			}
+		}
+
+		run.SystemTextReport(level)
+	}
+
+	if level >= 2 {
+		fmt.Printf("\n%8s %8s %8s %8s %8s %8s %8s %8s %8s\n", "workerid", "window", "p1", "p5", "p50", "p95", "p99", "iqr", "cov")
+		for set := range run.Results.Summary {
+			for id := range run.Results.Summary[set].Workers {
+				s := &run.Results.Summary[set].Workers[id]
+				s.TputDist.TextReport(fmt.Sprintf("%2d:%2d   ", set, id), "tput")
+				s.UtilDist.TextReport(fmt.Sprintf("%2d:%2d   ", set, id), "util")
+				if showWait {
+					s.RunnableDist.TextReport(fmt.Sprintf("%2d:%2d   ", set, id), rwait)
+				}
+			}
+		}
 	}
 
 	if level >= 1 {
- 		fmt.Printf("\n%8s %8s %8s %8s %8s %8s %8s %8s %8s %8s\n", "workerid", "toput", "time", "cpu", "tavg", "tmin", "tmax", "uavg", "umin", "umax")
+ 		showFair := run.Results.JainIndex > 0
+		fmt.Printf("\n%8s %8s %8s %8s %8s %8s %8s %8s %8s %8s", "workerid", "toput", "time", "cpu", "tavg", "tmin", "tmax", "uavg", "umin", "umax")
+		if showFair {
+			fmt.Printf(" %8s %8s %8s", "fshare", "fdev", "tratio")
+		}
+		if showWait {
+			fmt.Printf(" %8s", rwait)
+		}
+		fmt.Printf("\n")
 		for set := range run.Results.Summary {
 			for id := range run.Results.Summary[set].Workers {
 				s := run.Results.Summary[set].Workers[id]
-				fmt.Printf("%2d:%2d    %10d %8.2f %8.2f %8.2f %8.2f %8.2f %8.2f %8.2f %8.2f\n",
+				fmt.Printf("%2d:%2d    %10d %8.2f %8.2f %8.2f %8.2f %8.2f %8.2f %8.2f %8.2f",
 					set, id,
 					s.TotalTput, s.TotalTime.Seconds(), s.TotalCputime.Seconds(),
 					s.AvgTput, s.MinMaxTput.Min, s.MinMaxTput.Max,
 					s.AvgUtil, s.MinMaxUtil.Min, s.MinMaxUtil.Max)
+				if showFair {
+					fmt.Printf(" %8.2f %8.2f %8.2f", s.FairShare, s.FairDev, s.TputRatio)
+				}
+				if showWait {
+					fmt.Printf(" %8.2f", s.RunnableFrac)
+				}
+				fmt.Printf("\n")
 
 				if level >= 2 {
 					var le WorkerReport
@@ -443,18 +1028,97 @@ func (plan *BenchmarkPlan) Save() (err error) {
 	return
 }
 
-func (plan *BenchmarkPlan) TextReport(level int) (err error) {
+// Process all the runs, then fill in the things which need
+// information from outside each run.
+func (plan *BenchmarkPlan) Process() (err error) {
 	for i := range plan.Runs {
 		r := &plan.Runs[i]
-		if ! r.Completed {
-			fmt.Printf("Test [%d] %s not run\n", i, r.Label)
-		}
 
 		err = r.Process()
 		if err != nil {
-			fmt.Printf("Error processing [%d] %s: %v\n", i, r.Label, err)
+			err = fmt.Errorf("Error processing [%d] %s: %v", i, r.Label, err)
 			return
 		}
+	}
+
+	if plan.Calibration != nil {
+		for i := range plan.Runs {
+			r := &plan.Runs[i]
+			for set := range r.WorkerSets {
+				cal, ok := plan.Calibration.Presets[r.WorkerSets[set].Preset]
+				if !ok || cal.Tput == 0 {
+					continue
+				}
+				ws := &r.Results.Summary[set]
+				ws.RelTput = ws.AvgAvgTput / cal.Tput
+				for id := range ws.Workers {
+					ws.Workers[id].RelTput = ws.Workers[id].AvgTput / cal.Tput
+				}
+			}
+		}
+	}
+
+	for i := range plan.Runs {
+		plan.processFairness(&plan.Runs[i])
+		plan.processOverhead(&plan.Runs[i])
+	}
+
+	return
+}
+
+// The distinct host identities of the runs in the plan
+func (plan *BenchmarkPlan) Hosts() (hosts []string) {
+	seen := make(map[string]bool)
+	for i := range plan.Runs {
+		h := plan.Runs[i].Results.Host
+		if h == nil || seen[h.Identity()] {
+			continue
+		}
+		seen[h.Identity()] = true
+		hosts = append(hosts, h.Identity())
+	}
+	return
+}
+
+func (plan *BenchmarkPlan) TextReport(level int) (err error) {
+	err = plan.Process()
+	if err != nil {
+		return
+	}
+
+	if plan.Calibration != nil {
+		fmt.Printf("== CALIBRATION ==\n")
+		fmt.Printf("Burn rate: %.2f ns/kop\n", plan.Calibration.NsPerKop)
+		var names []string
+		for name := range plan.Calibration.Presets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Printf("\n%8s %8s %8s %8s\n", "preset", "tput", "ns/kop", "duty")
+		for _, name := range names {
+			cal := plan.Calibration.Presets[name]
+			fmt.Printf("%8s %8.2f %8.2f %8.2f\n", name, cal.Tput, cal.NsPerKop, cal.DutyCycle)
+		}
+		fmt.Printf("\n\n")
+	}
+
+	hosts := plan.Hosts()
+	if len(hosts) > 0 {
+		fmt.Printf("== HOSTS ==\n")
+		for _, h := range hosts {
+			fmt.Printf("%s\n", h)
+		}
+		if len(hosts) > 1 {
+			fmt.Printf("WARNING: Runs in this plan were done on %d different hosts or builds\n", len(hosts))
+		}
+		fmt.Printf("\n\n")
+	}
+
+	for i := range plan.Runs {
+		r := &plan.Runs[i]
+		if ! r.Completed {
+			fmt.Printf("Test [%d] %s not run\n", i, r.Label)
+		}
 
 		err = r.TextReport(level)
 		if err != nil {
@@ -462,6 +1126,16 @@ func (plan *BenchmarkPlan) TextReport(level int) (err error) {
 		}
 	}
 
+	scores := plan.SchedulerScores()
+	if len(scores) > 0 {
+		fmt.Printf("== SUBJECTIVE FAIRNESS ==\n")
+		fmt.Printf("\n%10s %8s %8s %8s\n", "scheduler", "sets", "score", "worst")
+		for _, sc := range scores {
+			fmt.Printf("%10s %8d %8.2f %8.2f\n", sc.Scheduler, sc.Sets, sc.Score, sc.Worst)
+		}
+		fmt.Printf("\n\n")
+	}
+
 	return
 }
 
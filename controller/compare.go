@@ -0,0 +1,224 @@
+/*
+ * Copyright (C) 2016 George W. Dunlap, Citrix Systems UK Ltd
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation; version 2 of the
+ * License only.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301, USA.
+ */
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	// Significance level for the comparisons
+	CompareAlpha = 0.05
+	// Smallest Cliff's delta not considered negligible (Romano et al.)
+	CompareMinEffect = 0.147
+)
+
+// A window-level metric which can be compared between runs.  If
+// Higher and Lower are given, they describe B relative to A when B's
+// values are higher or lower.
+type WindowMetric struct {
+	Name string
+	Higher string
+	Lower string
+	Samples func(s *WorkerSummary) []float64
+}
+
+var WindowMetrics = []WindowMetric{
+	{"tput", "better", "worse", func(s *WorkerSummary) []float64 {
+		tputs, _ := s.Windows()
+		return tputs
+	}},
+	{"util", "more", "less", func(s *WorkerSummary) []float64 {
+		_, utils := s.Windows()
+		return utils
+	}},
+}
+
+type Comparison struct {
+	Set int
+	Metric string
+	MeanA float64
+	MeanB float64
+	// (MeanB - MeanA) / MeanA, in percent
+	Delta float64
+	WelchP float64
+	MannWhitneyP float64
+	CohenD float64
+	CliffDelta float64
+	Verdict string
+}
+
+func Compare(set int, m *WindowMetric, a []float64, b []float64) (c Comparison) {
+	c.Set = set
+	c.Metric = m.Name
+	c.MeanA = Mean(a)
+	c.MeanB = Mean(b)
+	if c.MeanA != 0 {
+		c.Delta = (c.MeanB - c.MeanA) / c.MeanA * 100
+	}
+	c.WelchP = WelchTTest(a, b)
+	c.MannWhitneyP, c.CliffDelta = MannWhitney(a, b)
+	c.CohenD = CohenD(a, b)
+
+	// Window samples are far from normal, so go by the rank test
+	switch {
+	case len(a) < 2 || len(b) < 2:
+		c.Verdict = "n/a"
+	case c.MannWhitneyP >= CompareAlpha || math.Abs(c.CliffDelta) < CompareMinEffect:
+		c.Verdict = "same"
+	case c.CliffDelta > 0:
+		c.Verdict = m.Higher
+	default:
+		c.Verdict = m.Lower
+	}
+	return
+}
+
+// The window samples of each set, pooled over all the workers of all
+// the given runs
+func (plan *BenchmarkPlan) windowSamples(runs []int, m *WindowMetric) (samples [][]float64) {
+	for _, i := range runs {
+		r := &plan.Runs[i]
+		if samples == nil {
+			samples = make([][]float64, len(r.Results.Summary))
+		}
+		for set := range r.Results.Summary {
+			if set >= len(samples) {
+				break
+			}
+			ws := &r.Results.Summary[set]
+			for id := range ws.Workers {
+				samples[set] = append(samples[set], m.Samples(&ws.Workers[id])...)
+			}
+		}
+	}
+	return
+}
+
+// Compare runs which differ only along the given axis.  The first
+// value found along the axis (e.g., the first scheduler) is A, and
+// each other value is compared against it as B.
+func (plan *BenchmarkPlan) CompareReport(axisName string, level int) (err error) {
+	var axis *RunAxis
+	axis, err = FindAxis(axisName)
+	if err != nil {
+		return
+	}
+
+	err = plan.Process()
+	if err != nil {
+		return
+	}
+
+	type Group struct {
+		Key string
+		Values []string
+		Runs map[string][]int
+	}
+	var groups []*Group
+	index := make(map[string]*Group)
+	for i := range plan.Runs {
+		r := &plan.Runs[i]
+		if !r.Completed {
+			continue
+		}
+		key := r.ConfigKey(axis.Name)
+		g := index[key]
+		if g == nil {
+			g = &Group{Key:key, Runs:make(map[string][]int)}
+			index[key] = g
+			groups = append(groups, g)
+		}
+		v := axis.Value(r)
+		if g.Runs[v] == nil {
+			g.Values = append(g.Values, v)
+		}
+		g.Runs[v] = append(g.Runs[v], i)
+	}
+
+	type Tally struct {
+		Higher, Lower, Same int
+	}
+	tally := make(map[string]*Tally)
+	var tallyOrder []string
+
+	for _, g := range groups {
+		if len(g.Values) < 2 {
+			continue
+		}
+		va := g.Values[0]
+		for _, vb := range g.Values[1:] {
+			fmt.Printf("== COMPARE %s: %s (A) vs %s (B) ==\n", axis.Name, va, vb)
+			fmt.Printf("%s\n", g.Key)
+			if level >= 1 {
+				fmt.Printf("A: %d runs, B: %d runs\n", len(g.Runs[va]), len(g.Runs[vb]))
+			}
+			fmt.Printf("%8s %8s %8s %8s %8s %8s %8s %8s %8s %8s\n",
+				"set", "metric", "meanA", "meanB", "delta%", "welchp", "mwp", "cohend", "cliffd", "verdict")
+			for m := range WindowMetrics {
+				wm := &WindowMetrics[m]
+				a := plan.windowSamples(g.Runs[va], wm)
+				b := plan.windowSamples(g.Runs[vb], wm)
+				for set := range a {
+					if set >= len(b) {
+						break
+					}
+					c := Compare(set, wm, a[set], b[set])
+					fmt.Printf("%8d %8s %8.2f %8.2f %8.2f %8.4f %8.4f %8.2f %8.2f %8s\n",
+						c.Set, c.Metric, c.MeanA, c.MeanB, c.Delta,
+						c.WelchP, c.MannWhitneyP, c.CohenD, c.CliffDelta, c.Verdict)
+
+					if wm.Name != "tput" {
+						continue
+					}
+					tk := fmt.Sprintf("%s vs %s", vb, va)
+					t := tally[tk]
+					if t == nil {
+						t = &Tally{}
+						tally[tk] = t
+						tallyOrder = append(tallyOrder, tk)
+					}
+					switch c.Verdict {
+					case wm.Higher:
+						t.Higher++
+					case wm.Lower:
+						t.Lower++
+					case "same":
+						t.Same++
+					}
+				}
+			}
+			fmt.Printf("\n")
+		}
+	}
+
+	if len(tallyOrder) == 0 {
+		fmt.Printf("No runs differing only in %s\n", axis.Name)
+		return
+	}
+
+	fmt.Printf("== VERDICT (throughput, by set) ==\n")
+	fmt.Printf("%20s %8s %8s %8s\n", axis.Name, "better", "worse", "same")
+	for _, tk := range tallyOrder {
+		t := tally[tk]
+		fmt.Printf("%20s %8d %8d %8d\n", tk, t.Higher, t.Lower, t.Same)
+	}
+	return
+}
@@ -0,0 +1,275 @@
+/*
+ * Copyright (C) 2016 George W. Dunlap, Citrix Systems UK Ltd
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation; version 2 of the
+ * License only.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301, USA.
+ */
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const DiffDefaultTolerance = 5
+
+// Only regressions in these metrics (and any given their own
+// tolerance) make diff fail; the others are too noisy, or too close
+// to zero, for a relative tolerance to mean much.
+var DiffGateMetrics = []string{"ttotal", "tavgavg", "jain", "tratio"}
+
+// Changes smaller than this are never counted, whatever their
+// relative size.  Metrics not listed are in kops/s, where the
+// tolerance is enough.
+var DiffFloors = map[string]float64{
+	"tcov": 0.01, "trel": 0.01,
+	"utotal": 0.01, "uavgavg": 0.01, "ustdev": 0.01, "uavgmax": 0.01,
+	"uavgmin": 0.01, "utotmax": 0.01, "utotmin": 0.01, "up5": 0.01,
+	"up50": 0.01, "ucov": 0.01, "rwaitavg": 0.01, "fshare": 0.01,
+	"fdevavg": 0.01, "fdevmax": 0.01, "jain": 0.01, "tdegr": 0.01,
+	"texpdegr": 0.01, "tratio": 0.01,
+}
+
+// Allowed change in each metric, in percent
+type Tolerances struct {
+	Default float64
+	Metric map[string]float64
+}
+
+func NewTolerances() Tolerances {
+	return Tolerances{Default:DiffDefaultTolerance, Metric:make(map[string]float64)}
+}
+
+// Parse either "N", to set the default, or "metric=N"
+func (t *Tolerances) Set(s string) (err error) {
+	name := ""
+	if i := strings.Index(s, "="); i >= 0 {
+		name = s[:i]
+		s = s[i+1:]
+	}
+
+	var tol float64
+	tol, err = strconv.ParseFloat(s, 64)
+	if err != nil {
+		return
+	}
+	if tol < 0 {
+		err = fmt.Errorf("Negative tolerance %s", s)
+		return
+	}
+
+	if name == "" {
+		t.Default = tol
+		return
+	}
+
+	for m := range SetMetrics {
+		if SetMetrics[m].Name == name {
+			t.Metric[name] = tol
+			return
+		}
+	}
+	err = fmt.Errorf("Unknown metric %s", name)
+	return
+}
+
+// Whether a regression in the metric makes diff fail
+func (t *Tolerances) Gate(name string) bool {
+	if _, ok := t.Metric[name]; ok {
+		return true
+	}
+	for _, g := range DiffGateMetrics {
+		if g == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *Tolerances) Get(name string) float64 {
+	if tol, ok := t.Metric[name]; ok {
+		return tol
+	}
+	return t.Default
+}
+
+const (
+	DiffUnchanged = iota
+	DiffImproved
+	DiffRegressed
+	// Beyond the tolerance, for a metric which is neither better
+	// nor worse when it goes up
+	DiffChanged
+)
+
+var DiffStatusNames = []string{"unchanged", "improved", "regressed", "changed"}
+
+// Compare the mean of a metric before and after; change is in
+// percent of the old value.  A change only counts if it's more than
+// the tolerance and the metric's floor, and, if the old runs were
+// repeated, takes the new mean outside their confidence interval.
+func DiffMetric(m *SetMetric, old MetricStats, now float64, tol float64) (change float64, status int) {
+	was := old.Mean
+	if was == now {
+		return
+	}
+	if was == 0 {
+		change = math.Inf(1)
+		if now < 0 {
+			change = math.Inf(-1)
+		}
+	} else {
+		change = (now - was) / math.Abs(was) * 100
+	}
+	if math.Abs(change) <= tol || math.Abs(now - was) <= DiffFloors[m.Name] {
+		return
+	}
+	if old.N > 1 && now >= old.CILow && now <= old.CIHigh {
+		return
+	}
+
+	switch {
+	case m.Better == 0:
+		status = DiffChanged
+	case (change > 0) == (m.Better > 0):
+		status = DiffImproved
+	default:
+		status = DiffRegressed
+	}
+	return
+}
+
+// Compare the runs of two plans which have the same configuration,
+// averaging over repeats.  Returns the number of regressions.
+func DiffReport(oldPlan *BenchmarkPlan, newPlan *BenchmarkPlan, tol Tolerances, level int) (regressions int, err error) {
+	err = oldPlan.Process()
+	if err != nil {
+		return
+	}
+	err = newPlan.Process()
+	if err != nil {
+		return
+	}
+
+	oldHosts, newHosts := oldPlan.Hosts(), newPlan.Hosts()
+	for _, h := range oldHosts {
+		fmt.Printf("Old host: %s\n", h)
+	}
+	for _, h := range newHosts {
+		fmt.Printf("New host: %s\n", h)
+	}
+	if len(oldHosts) + len(newHosts) > 0 {
+		fmt.Printf("\n")
+	}
+
+	oldGroups := oldPlan.GroupRuns()
+	newGroups := newPlan.GroupRuns()
+	oldIndex := make(map[string]int)
+	for g := range oldGroups {
+		oldIndex[oldGroups[g].Key] = g
+	}
+
+	// Per metric, the number of sets with each status
+	counts := make([][]int, len(SetMetrics))
+	for m := range counts {
+		counts[m] = make([]int, len(DiffStatusNames))
+	}
+
+	matched := make(map[string]bool)
+	onlyNew := 0
+	for _, ng := range newGroups {
+		g, ok := oldIndex[ng.Key]
+		if !ok {
+			onlyNew++
+			if level >= 1 {
+				fmt.Printf("Only in new: %s\n", ng.Label)
+			}
+			continue
+		}
+		og := &oldGroups[g]
+		matched[ng.Key] = true
+
+		printed := false
+		for set := range ng.Sets {
+			if set >= len(og.Sets) {
+				break
+			}
+			for m := range SetMetrics {
+				o := og.Sets[set][m].Mean
+				n := ng.Sets[set][m].Mean
+				// Not calculated for either plan
+				if o == 0 && n == 0 {
+					continue
+				}
+				change, status := DiffMetric(&SetMetrics[m], og.Sets[set][m], n, tol.Get(SetMetrics[m].Name))
+				counts[m][status]++
+				if status == DiffRegressed && tol.Gate(SetMetrics[m].Name) {
+					regressions++
+				}
+				if status == DiffUnchanged && level < 1 {
+					continue
+				}
+				if !printed {
+					fmt.Printf("== DIFF %s (%d -> %d runs) ==\n", ng.Label, len(og.Runs), len(ng.Runs))
+					fmt.Printf("%8s %8s %8s %8s %8s %8s\n", "set", "metric", "old", "new", "change%", "status")
+					printed = true
+				}
+				fmt.Printf("%8d %8s %8.2f %8.2f %8.2f %8s\n",
+					set, SetMetrics[m].Name, o, n, change, DiffStatusNames[status])
+			}
+		}
+		if printed {
+			fmt.Printf("\n")
+		}
+	}
+
+	onlyOld := 0
+	for g := range oldGroups {
+		if !matched[oldGroups[g].Key] {
+			onlyOld++
+			if level >= 1 {
+				fmt.Printf("Only in old: %s\n", oldGroups[g].Label)
+			}
+		}
+	}
+
+	fmt.Printf("== DIFF SUMMARY: %d configurations matched, %d only in old, %d only in new ==\n",
+		len(matched), onlyOld, onlyNew)
+	fmt.Printf("%8s %8s %8s %8s %8s %8s %8s\n", "metric", "tol%", "gate", "improved", "regressd", "changed", "same")
+	for m := range SetMetrics {
+		c := counts[m]
+		if c[DiffImproved] + c[DiffRegressed] + c[DiffChanged] + c[DiffUnchanged] == 0 {
+			continue
+		}
+		gate := "no"
+		if tol.Gate(SetMetrics[m].Name) {
+			gate = "yes"
+		}
+		fmt.Printf("%8s %8.2f %8s %8d %8d %8d %8d\n", SetMetrics[m].Name, tol.Get(SetMetrics[m].Name),
+			gate, c[DiffImproved], c[DiffRegressed], c[DiffChanged], c[DiffUnchanged])
+	}
+	fmt.Printf("%d regressions in gating metrics\n", regressions)
+	if onlyOld + onlyNew > 0 {
+		fmt.Printf("WARNING: %d configurations only in old, %d only in new, not compared\n",
+			onlyOld, onlyNew)
+	}
+	// Nothing compared can't count as passing
+	if len(matched) == 0 {
+		err = fmt.Errorf("no configurations matched")
+	}
+	return
+}
@@ -0,0 +1,238 @@
+/*
+ * Copyright (C) 2016 George W. Dunlap, Citrix Systems UK Ltd
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation; version 2 of the
+ * License only.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301, USA.
+ */
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// The worker metrics exported, named as in the text report
+var WorkerColumns = []struct{
+	Name string
+	Value func(s *WorkerSummary) float64
+}{
+	{"toput", func(s *WorkerSummary) float64 { return float64(s.TotalTput) }},
+	{"time", func(s *WorkerSummary) float64 { return s.TotalTime.Seconds() }},
+	{"cpu", func(s *WorkerSummary) float64 { return s.TotalCputime.Seconds() }},
+	{"tavg", func(s *WorkerSummary) float64 { return s.AvgTput }},
+	{"tmin", func(s *WorkerSummary) float64 { return s.MinMaxTput.Min }},
+	{"tmax", func(s *WorkerSummary) float64 { return s.MinMaxTput.Max }},
+	{"tp5", func(s *WorkerSummary) float64 { return s.TputDist.P5 }},
+	{"tp50", func(s *WorkerSummary) float64 { return s.TputDist.P50 }},
+	{"tp95", func(s *WorkerSummary) float64 { return s.TputDist.P95 }},
+	{"tcov", func(s *WorkerSummary) float64 { return s.TputDist.CoV }},
+	{"uavg", func(s *WorkerSummary) float64 { return s.AvgUtil }},
+	{"umin", func(s *WorkerSummary) float64 { return s.MinMaxUtil.Min }},
+	{"umax", func(s *WorkerSummary) float64 { return s.MinMaxUtil.Max }},
+	{"trel", func(s *WorkerSummary) float64 { return s.RelTput }},
+	{"fshare", func(s *WorkerSummary) float64 { return s.FairShare }},
+	{"fdev", func(s *WorkerSummary) float64 { return s.FairDev }},
+	{"tratio", func(s *WorkerSummary) float64 { return s.TputRatio }},
+	{"rwait", func(s *WorkerSummary) float64 { return s.RunnableFrac }},
+	{"rtrips", func(s *WorkerSummary) float64 { return s.MsgRate }},
+	{"rttavg", func(s *WorkerSummary) float64 { return s.AvgRtt / USEC }},
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Columns identifying a run, shared by all the files
+func runHeader() (h []string) {
+	h = []string{"run", "label", "repeat", "baseline"}
+	for i := range RunAxes {
+		h = append(h, RunAxes[i].Name)
+	}
+	return
+}
+
+func runColumns(i int, run *BenchmarkRun) (c []string) {
+	c = []string{strconv.Itoa(i), run.Label, strconv.Itoa(run.Repeat),
+		strconv.FormatBool(run.Baseline)}
+	for a := range RunAxes {
+		c = append(c, RunAxes[a].Value(run))
+	}
+	return
+}
+
+type exportFile struct {
+	f *os.File
+	w *csv.Writer
+}
+
+func createExportFile(filename string, format string, header []string) (ef *exportFile, err error) {
+	ef = &exportFile{}
+	ef.f, err = os.Create(filename)
+	if err != nil {
+		return
+	}
+	ef.w = csv.NewWriter(ef.f)
+	if format == "tsv" {
+		ef.w.Comma = '\t'
+	}
+	err = ef.w.Write(header)
+	if err != nil {
+		ef.f.Close()
+		return
+	}
+	fmt.Printf("Writing %s\n", filename)
+	return
+}
+
+func (ef *exportFile) Close() (err error) {
+	ef.w.Flush()
+	err = ef.w.Error()
+	if cerr := ef.f.Close(); err == nil {
+		err = cerr
+	}
+	return
+}
+
+// Write the set summaries, worker summaries and report windows of
+// the completed runs to prefix-sets, prefix-workers and
+// prefix-windows, one row each, as csv or tsv.
+func (plan *BenchmarkPlan) Export(prefix string, format string) (err error) {
+	switch format {
+	case "":
+		format = "csv"
+	case "csv", "tsv":
+	default:
+		err = fmt.Errorf("Unknown export format %s", format)
+		return
+	}
+
+	err = plan.Process()
+	if err != nil {
+		return
+	}
+
+	if prefix == "" {
+		prefix = strings.TrimSuffix(plan.filename, ".bench")
+	}
+
+	var sets, workers, windows *exportFile
+
+	h := append(runHeader(), "set", "preset", "setcount")
+	for m := range SetMetrics {
+		h = append(h, SetMetrics[m].Name)
+	}
+	sets, err = createExportFile(prefix+"-sets."+format, format, h)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if cerr := sets.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	h = append(runHeader(), "set", "id")
+	for c := range WorkerColumns {
+		h = append(h, WorkerColumns[c].Name)
+	}
+	workers, err = createExportFile(prefix+"-workers."+format, format, h)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if cerr := workers.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	h = append(runHeader(), "set", "id", "window", "start", "end", "tput", "util", "anomaly")
+	windows, err = createExportFile(prefix+"-windows."+format, format, h)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if cerr := windows.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	for i := range plan.Runs {
+		r := &plan.Runs[i]
+		if !r.Completed {
+			continue
+		}
+		rc := runColumns(i, r)
+
+		anomalies := make(map[WorkerId]map[int]string)
+		for _, a := range r.Results.Anomalies {
+			if a.Window < 0 {
+				continue
+			}
+			if anomalies[a.Id] == nil {
+				anomalies[a.Id] = make(map[int]string)
+			}
+			anomalies[a.Id][a.Window] = a.Kind
+		}
+
+		for set := range r.Results.Summary {
+			ws := &r.Results.Summary[set]
+			row := append(rc[:len(rc):len(rc)], strconv.Itoa(set),
+				r.WorkerSets[set].Preset, strconv.Itoa(r.WorkerSets[set].Count))
+			for m := range SetMetrics {
+				row = append(row, formatFloat(SetMetrics[m].Value(ws)))
+			}
+			err = sets.w.Write(row)
+			if err != nil {
+				return
+			}
+
+			for id := range ws.Workers {
+				s := &ws.Workers[id]
+				row = append(rc[:len(rc):len(rc)], strconv.Itoa(set), strconv.Itoa(id))
+				for c := range WorkerColumns {
+					row = append(row, formatFloat(WorkerColumns[c].Value(s)))
+				}
+				err = workers.w.Write(row)
+				if err != nil {
+					return
+				}
+
+				if len(s.Raw) == 0 {
+					continue
+				}
+				// Times are from the worker's first report
+				start := s.Raw[0].Now
+				for w := 1; w < len(s.Raw); w++ {
+					l, e := &s.Raw[w-1], &s.Raw[w]
+					row = append(rc[:len(rc):len(rc)], strconv.Itoa(set), strconv.Itoa(id),
+						strconv.Itoa(w),
+						formatFloat(float64(l.Now - start) / SEC),
+						formatFloat(float64(e.Now - start) / SEC),
+						formatFloat(Throughput(l.Now, l.Kops, e.Now, e.Kops)),
+						formatFloat(Utilization(l.Now, l.Cputime, e.Now, e.Cputime)),
+						anomalies[WorkerId{Set:set, Id:id}][w])
+					err = windows.w.Write(row)
+					if err != nil {
+						return
+					}
+				}
+			}
+		}
+	}
+	return
+}
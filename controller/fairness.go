@@ -0,0 +1,262 @@
+/*
+ * Copyright (C) 2016 George W. Dunlap, Citrix Systems UK Ltd
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation; version 2 of the
+ * License only.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * General Public License for more details.
+ * 
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301, USA.
+ */
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// Divide capacity between consumers with the given demands, such
+// that nobody gets more than they want, and everyone who gets less
+// than they want gets the same amount ("water-filling").
+func MaxMinFairShare(capacity float64, demand []float64) (share []float64) {
+	share = make([]float64, len(demand))
+
+	order := make([]int, len(demand))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool { return demand[order[i]] < demand[order[j]] })
+
+	remaining := capacity
+	for k, i := range order {
+		even := remaining / float64(len(order) - k)
+		share[i] = math.Min(demand[i], even)
+		remaining -= share[i]
+	}
+	return
+}
+
+// Jain's fairness index: 1 if all x are equal, down to 1/n if one
+// gets everything.
+func JainIndex(x []float64) float64 {
+	var sum, sumsq float64
+	for _, v := range x {
+		sum += v
+		sumsq += v * v
+	}
+	if sumsq == 0 {
+		return 0
+	}
+	return sum * sum / (float64(len(x)) * sumsq)
+}
+
+// Find the baseline run for the preset of the given set, with the
+// same scheduler and NUMA setting; the same repetition if there is
+// one.
+func (plan *BenchmarkPlan) findBaseline(run *BenchmarkRun, set int) (index int, found bool) {
+	preset := run.WorkerSets[set].Preset
+	if preset == "" {
+		return
+	}
+
+	for i := range plan.Runs {
+		b := &plan.Runs[i]
+		if !b.Baseline ||
+			b.RunConfig.Scheduler != run.RunConfig.Scheduler ||
+			numaDisabled(b.RunConfig) != numaDisabled(run.RunConfig) {
+			continue
+		}
+		for bset := range b.WorkerSets {
+			if b.WorkerSets[bset].Preset == preset {
+				if !found || b.Repeat == run.Repeat {
+					index = i
+					found = true
+				}
+				if b.Repeat == run.Repeat {
+					return
+				}
+			}
+		}
+	}
+	return
+}
+
+// Point every set at the baseline run for its preset.  Baselines
+// point at themselves.
+func (plan *BenchmarkPlan) LinkBaselines() {
+	for i := range plan.Runs {
+		r := &plan.Runs[i]
+		for set := range r.WorkerSets {
+			if index, found := plan.findBaseline(r, set); found {
+				r.WorkerSets[set].BaselineRun = new(int)
+				*r.WorkerSets[set].BaselineRun = index
+			}
+		}
+	}
+}
+
+// The summary of the completed baseline for the given set, if any;
+// plans made before baselines were linked, or linked without regard
+// to repeats, are matched up by hand.
+func (plan *BenchmarkPlan) baselineSet(run *BenchmarkRun, set int) (ws *WorkerSetSummary) {
+	index := -1
+	if p := run.WorkerSets[set].BaselineRun; p != nil &&
+		*p >= 0 && *p < len(plan.Runs) && plan.Runs[*p].Repeat == run.Repeat {
+		index = *p
+	} else {
+		var found bool
+		index, found = plan.findBaseline(run, set)
+		if !found {
+			return
+		}
+	}
+	if index < 0 || index >= len(plan.Runs) {
+		return
+	}
+
+	b := &plan.Runs[index]
+	if !b.Completed {
+		return
+	}
+	for bset := range b.WorkerSets {
+		if b.WorkerSets[bset].Preset == run.WorkerSets[set].Preset {
+			ws = &b.Results.Summary[bset]
+			return
+		}
+	}
+	return
+}
+
+func numaDisabled(rc RunConfig) bool {
+	return rc.NumaDisable != nil && *rc.NumaDisable
+}
+
+// Objective fairness: compare the cpu each worker got with its
+// max-min fair share of the pool, taking what each worker wants to be
+// what it used in its baseline run.
+//
+// Subjective fairness: a worker which gets some fraction of the cpu
+// it wants should get about that fraction of its baseline
+// throughput.  TputRatio is the throughput a worker actually got
+// divided by that expected throughput.
+func (plan *BenchmarkPlan) processFairness(run *BenchmarkRun) {
+	rc := run.RunConfig
+	rc.PropagateFrom(plan.RunConfig)
+	if len(rc.Cpus) == 0 || !run.Completed {
+		return
+	}
+
+	var demand []float64
+	baselines := make([]*WorkerSetSummary, len(run.WorkerSets))
+	for set := range run.WorkerSets {
+		b := plan.baselineSet(run, set)
+		if b == nil || len(b.Workers) == 0 {
+			return
+		}
+		baselines[set] = b
+		ws := &run.Results.Summary[set]
+		if len(ws.Workers) == 0 {
+			return
+		}
+		// Workers only have one vcpu
+		for range ws.Workers {
+			demand = append(demand, math.Min(b.AvgAvgUtil, 1))
+		}
+	}
+
+	share := MaxMinFairShare(float64(len(rc.Cpus)), demand)
+
+	var all []float64
+	i := 0
+	for set := range run.Results.Summary {
+		ws := &run.Results.Summary[set]
+
+		var x []float64
+		ws.FairShare = 0
+		ws.AvgFairDev = 0
+		ws.MaxFairDev = 0
+		ws.ExpectedTput = 0
+		for id := range ws.Workers {
+			s := &ws.Workers[id]
+			d := demand[i]
+			s.FairShare = share[i]
+			i++
+			if s.FairShare == 0 {
+				continue
+			}
+			s.FairDev = (s.AvgUtil - s.FairShare) / s.FairShare
+
+			s.ExpectedTput = baselines[set].AvgAvgTput * s.FairShare / d
+			s.TputRatio = s.AvgTput / s.ExpectedTput
+			ws.ExpectedTput += s.ExpectedTput
+
+			ws.FairShare += s.FairShare
+			ws.AvgFairDev += s.FairDev
+			ws.MaxFairDev = math.Max(ws.MaxFairDev, math.Abs(s.FairDev))
+			x = append(x, s.AvgUtil / s.FairShare)
+		}
+		if len(x) == 0 {
+			continue
+		}
+		ws.FairShare /= float64(len(x))
+		ws.AvgFairDev /= float64(len(x))
+		ws.JainIndex = JainIndex(x)
+		all = append(all, x...)
+
+		ws.ExpectedTput /= float64(len(x))
+		ws.Degradation = ws.AvgAvgTput / baselines[set].AvgAvgTput
+		ws.ExpectedDegradation = ws.ExpectedTput / baselines[set].AvgAvgTput
+		ws.TputRatio = ws.AvgAvgTput / ws.ExpectedTput
+	}
+	run.Results.JainIndex = JainIndex(all)
+}
+
+type SchedulerScore struct {
+	Scheduler string
+	Sets int
+	// Geometric mean of the sets' TputRatio, and the worst one
+	Score float64
+	Worst float64
+}
+
+// Summarize subjective fairness for each scheduler across all the
+// non-baseline runs in the plan.  Must be called after Process.
+func (plan *BenchmarkPlan) SchedulerScores() (scores []SchedulerScore) {
+	index := make(map[string]int)
+	logsum := make(map[string]float64)
+	for i := range plan.Runs {
+		r := &plan.Runs[i]
+		if r.Baseline {
+			continue
+		}
+		for set := range r.Results.Summary {
+			ws := &r.Results.Summary[set]
+			// A zero baseline makes the ratio NaN or infinite
+			if !(ws.TputRatio > 0) || math.IsInf(ws.TputRatio, 0) {
+				continue
+			}
+			j, ok := index[r.RunConfig.Scheduler]
+			if !ok {
+				j = len(scores)
+				index[r.RunConfig.Scheduler] = j
+				scores = append(scores, SchedulerScore{Scheduler:r.RunConfig.Scheduler, Worst:ws.TputRatio})
+			}
+			sc := &scores[j]
+			sc.Sets++
+			sc.Worst = math.Min(sc.Worst, ws.TputRatio)
+			logsum[sc.Scheduler] += math.Log(ws.TputRatio)
+		}
+	}
+	for j := range scores {
+		scores[j].Score = math.Exp(logsum[scores[j].Scheduler] / float64(scores[j].Sets))
+	}
+	return
+}
@@ -0,0 +1,262 @@
+/*
+ * Copyright (C) 2016 George W. Dunlap, Citrix Systems UK Ltd
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation; version 2 of the
+ * License only.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301, USA.
+ */
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"strings"
+)
+
+var GnuplotTerminals = map[string]string{
+	"png": "pngcairo noenhanced size 1024,768",
+	"svg": "svg noenhanced size 1024,768 dynamic",
+	"pdf": "pdfcairo noenhanced size 8in,6in",
+}
+
+func gnuplotString(s string) string {
+	s = strings.Replace(s, "\\", "\\\\", -1)
+	return "\"" + strings.Replace(s, "\"", "\\\"", -1) + "\""
+}
+
+// Writes data files and scripts with a common prefix.  Scripts refer
+// to the data files without the directory, so they should be run
+// from the directory they're in.
+type GnuplotWriter struct {
+	prefix string
+	format string
+}
+
+func (g *GnuplotWriter) name(suffix string) string {
+	return filepath.Base(g.prefix) + "-" + suffix
+}
+
+func (g *GnuplotWriter) write(suffix string, b *bytes.Buffer) (err error) {
+	filename := g.prefix + "-" + suffix
+	fmt.Printf("Writing %s\n", filename)
+	err = ioutil.WriteFile(filename, b.Bytes(), 0666)
+	return
+}
+
+func (g *GnuplotWriter) header(b *bytes.Buffer) {
+	fmt.Fprintf(b, "set terminal %s\n", GnuplotTerminals[g.format])
+	fmt.Fprintf(b, "set key outside right\n")
+	fmt.Fprintf(b, "set grid\n")
+}
+
+func (g *GnuplotWriter) output(b *bytes.Buffer, name string) {
+	fmt.Fprintf(b, "\nset output %s\n", gnuplotString(g.name(name + "." + g.format)))
+}
+
+// Each worker's throughput and utilization in each window, with a
+// block (gnuplot index) per worker
+func (g *GnuplotWriter) runData(i int, run *BenchmarkRun) (labels []string, err error) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# %s\n", run.Label)
+	for set := range run.Results.Summary {
+		for id := range run.Results.Summary[set].Workers {
+			s := &run.Results.Summary[set].Workers[id]
+			labels = append(labels, fmt.Sprintf("%d:%d", set, id))
+			fmt.Fprintf(&b, "# worker %d:%d\n# time tput util\n", set, id)
+			for w := 1; w < len(s.Raw); w++ {
+				l, e := &s.Raw[w-1], &s.Raw[w]
+				fmt.Fprintf(&b, "%f %f %f\n", float64(e.Now) / SEC,
+					Throughput(l.Now, l.Kops, e.Now, e.Kops),
+					Utilization(l.Now, l.Cputime, e.Now, e.Cputime))
+			}
+			fmt.Fprintf(&b, "\n\n")
+		}
+	}
+	err = g.write(fmt.Sprintf("run%d.dat", i), &b)
+	return
+}
+
+func (plan *BenchmarkPlan) Gnuplot(prefix string, format string) (err error) {
+	if format == "" {
+		format = "png"
+	}
+	if _, ok := GnuplotTerminals[format]; !ok {
+		err = fmt.Errorf("Unknown gnuplot terminal %s", format)
+		return
+	}
+
+	err = plan.Process()
+	if err != nil {
+		return
+	}
+
+	if prefix == "" {
+		prefix = strings.TrimSuffix(plan.filename, ".bench")
+	}
+	g := &GnuplotWriter{prefix:prefix, format:format}
+
+	// Throughput and utilization over time, a plot per run
+	var tput, util bytes.Buffer
+	g.header(&tput)
+	g.header(&util)
+	fmt.Fprintf(&tput, "set xlabel \"Time (s)\"\nset ylabel \"Throughput (kOps)\"\nset yrange [0:*]\n")
+	fmt.Fprintf(&util, "set xlabel \"Time (s)\"\nset ylabel \"Utilization\"\nset yrange [0:*]\n")
+	for i := range plan.Runs {
+		r := &plan.Runs[i]
+		if !r.Completed {
+			continue
+		}
+		var labels []string
+		labels, err = g.runData(i, r)
+		if err != nil {
+			return
+		}
+		if len(labels) == 0 {
+			continue
+		}
+		data := gnuplotString(g.name(fmt.Sprintf("run%d.dat", i)))
+		for _, v := range []struct{
+			b *bytes.Buffer
+			name string
+			title string
+			col int
+		}{
+			{&tput, "tput", "Throughput", 2},
+			{&util, "util", "Utilization", 3},
+		} {
+			g.output(v.b, fmt.Sprintf("%s-run%d", v.name, i))
+			fmt.Fprintf(v.b, "set title %s\n", gnuplotString(fmt.Sprintf("Run %s Individual %s", r.Label, v.title)))
+			fmt.Fprintf(v.b, "plot")
+			for w, label := range labels {
+				if w > 0 {
+					fmt.Fprintf(v.b, ",")
+				}
+				fmt.Fprintf(v.b, " \\\n  %s index %d using 1:%d with points title %s",
+					data, w, v.col, gnuplotString("Worker " + label))
+			}
+			fmt.Fprintf(v.b, "\n")
+		}
+	}
+	err = g.write("tput.gp", &tput)
+	if err != nil {
+		return
+	}
+	err = g.write("util.gp", &util)
+	if err != nil {
+		return
+	}
+
+	// Scaling curves: each set metric against the number of
+	// workers, a line per scheduler
+	var scaling bytes.Buffer
+	g.header(&scaling)
+	fmt.Fprintf(&scaling, "set xlabel \"Workers\"\nset yrange [0:*]\n")
+	for c, curve := range plan.ScalingCurves() {
+		if len(curve.Workers) < 2 {
+			continue
+		}
+		for set := 0; set < curve.Sets; set++ {
+			var b bytes.Buffer
+			fmt.Fprintf(&b, "# %s set %d\n# workers", curve.Key, set)
+			var ys [][][]float64
+			var names []string
+			for _, name := range ScalingMetrics {
+				y, ok := curve.Series(set, findSetMetric(name))
+				if !ok {
+					continue
+				}
+				ys = append(ys, y)
+				names = append(names, name)
+				for _, sched := range curve.Schedulers {
+					fmt.Fprintf(&b, " %s-%s", name, sched)
+				}
+			}
+			fmt.Fprintf(&b, "\n")
+			for i, n := range curve.Workers {
+				fmt.Fprintf(&b, "%d", n)
+				for m := range ys {
+					for s := range ys[m] {
+						if math.IsNaN(ys[m][s][i]) {
+							fmt.Fprintf(&b, " NaN")
+						} else {
+							fmt.Fprintf(&b, " %f", ys[m][s][i])
+						}
+					}
+				}
+				fmt.Fprintf(&b, "\n")
+			}
+			dataName := fmt.Sprintf("scaling%d-set%d.dat", c, set)
+			err = g.write(dataName, &b)
+			if err != nil {
+				return
+			}
+
+			col := 2
+			for _, name := range names {
+				g.output(&scaling, fmt.Sprintf("scaling%d-set%d-%s", c, set, name))
+				fmt.Fprintf(&scaling, "set title %s\n", gnuplotString(fmt.Sprintf("%s: set %d %s", curve.Key, set, name)))
+				fmt.Fprintf(&scaling, "set ylabel %s\n", gnuplotString(name))
+				fmt.Fprintf(&scaling, "plot")
+				for s, sched := range curve.Schedulers {
+					if s > 0 {
+						fmt.Fprintf(&scaling, ",")
+					}
+					fmt.Fprintf(&scaling, " \\\n  %s using 1:%d with linespoints title %s",
+						gnuplotString(g.name(dataName)), col, gnuplotString(sched))
+					col++
+				}
+				fmt.Fprintf(&scaling, "\n")
+			}
+		}
+	}
+	err = g.write("scaling.gp", &scaling)
+	if err != nil {
+		return
+	}
+
+	// Fairness: each worker's fair share next to the utilization it
+	// got, a plot per run
+	var fairness bytes.Buffer
+	g.header(&fairness)
+	fmt.Fprintf(&fairness, "set style data histograms\nset style histogram clustered\nset style fill solid 0.5 border -1\n")
+	fmt.Fprintf(&fairness, "set xlabel \"Worker\"\nset ylabel \"Cpu\"\nset yrange [0:*]\n")
+	for i := range plan.Runs {
+		r := &plan.Runs[i]
+		if !r.Completed || r.Results.JainIndex == 0 {
+			continue
+		}
+		var b bytes.Buffer
+		fmt.Fprintf(&b, "# %s\n# worker fshare uavg\n", r.Label)
+		for set := range r.Results.Summary {
+			for id := range r.Results.Summary[set].Workers {
+				s := &r.Results.Summary[set].Workers[id]
+				fmt.Fprintf(&b, "%d:%d %f %f\n", set, id, s.FairShare, s.AvgUtil)
+			}
+		}
+		dataName := fmt.Sprintf("fairness%d.dat", i)
+		err = g.write(dataName, &b)
+		if err != nil {
+			return
+		}
+		g.output(&fairness, fmt.Sprintf("fairness-run%d", i))
+		fmt.Fprintf(&fairness, "set title %s\n", gnuplotString(fmt.Sprintf("Run %s Fairness (Jain's index %.3f)", r.Label, r.Results.JainIndex)))
+		fmt.Fprintf(&fairness, "plot %s using 2:xtic(1) title \"Fair share\", '' using 3 title \"Utilization\"\n",
+			gnuplotString(g.name(dataName)))
+	}
+	err = g.write("fairness.gp", &fairness)
+	return
+}
@@ -0,0 +1,262 @@
+/*
+ * Copyright (C) 2016 George W. Dunlap, Citrix Systems UK Ltd
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation; version 2 of the
+ * License only.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301, USA.
+ */
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// One of the ways in which runs in a plan can differ from each other
+type RunAxis struct {
+	Name string
+	Value func(run *BenchmarkRun) string
+}
+
+func setArgs(ws *WorkerSet) string {
+	if ws.Preset != "" {
+		return ws.Preset
+	}
+	args := ws.Params.Args
+	// The frequency is filled in at run time
+	if len(args) >= 2 && args[0] == "kHZ" {
+		args = args[2:]
+	}
+	return strings.Join(args, " ")
+}
+
+var RunAxes = []RunAxis{
+	{"workers", func(run *BenchmarkRun) string {
+		var s []string
+		for set := range run.WorkerSets {
+			ws := &run.WorkerSets[set]
+			w := setArgs(ws)
+			if ws.PairWith != nil {
+				w = fmt.Sprintf("%s<->%d", w, *ws.PairWith)
+			}
+			s = append(s, w)
+		}
+		return strings.Join(s, ",")
+	}},
+	{"count", func(run *BenchmarkRun) string {
+		var s []string
+		for set := range run.WorkerSets {
+			s = append(s, fmt.Sprintf("%d", run.WorkerSets[set].Count))
+		}
+		return strings.Join(s, ",")
+	}},
+	{"scheduler", func(run *BenchmarkRun) string { return run.RunConfig.Scheduler }},
+	{"numa", func(run *BenchmarkRun) string {
+		if run.RunConfig.NumaDisable == nil {
+			return ""
+		}
+		if *run.RunConfig.NumaDisable {
+			return "off"
+		}
+		return "on"
+	}},
+	{"pool", func(run *BenchmarkRun) string { return run.RunConfig.Pool }},
+	{"cpus", func(run *BenchmarkRun) string { return fmt.Sprint(run.RunConfig.Cpus) }},
+	{"runtime", func(run *BenchmarkRun) string { return fmt.Sprintf("%d", run.RuntimeSeconds) }},
+}
+
+func FindAxis(name string) (axis *RunAxis, err error) {
+	for i := range RunAxes {
+		if RunAxes[i].Name == name {
+			axis = &RunAxes[i]
+			return
+		}
+	}
+	err = fmt.Errorf("Unknown axis %s", name)
+	return
+}
+
+// A string identifying the configuration of the run, leaving out the
+// given axes.  Runs which are repeats of each other have the same key.
+func (run *BenchmarkRun) ConfigKey(except ...string) string {
+	var s []string
+outer:
+	for i := range RunAxes {
+		for _, e := range except {
+			if RunAxes[i].Name == e {
+				continue outer
+			}
+		}
+		s = append(s, RunAxes[i].Name+"="+RunAxes[i].Value(run))
+	}
+	return strings.Join(s, " ")
+}
+
+// The metrics of a worker set which can be compared across runs,
+// named as in the text report.  Better is 1 if higher is better, -1
+// if lower is better, and 0 if neither.
+type SetMetric struct {
+	Name string
+	Better int
+	Value func(ws *WorkerSetSummary) float64
+}
+
+var SetMetrics = []SetMetric{
+	{"ttotal", 1, func(ws *WorkerSetSummary) float64 { return ws.TotalTput }},
+	{"tavgavg", 1, func(ws *WorkerSetSummary) float64 { return ws.AvgAvgTput }},
+	{"tstdev", -1, func(ws *WorkerSetSummary) float64 { return ws.AvgStdDevTput }},
+	{"tavgmax", 0, func(ws *WorkerSetSummary) float64 { return ws.MinMaxAvgTput.Max }},
+	{"tavgmin", 1, func(ws *WorkerSetSummary) float64 { return ws.MinMaxAvgTput.Min }},
+	{"ttotmax", 0, func(ws *WorkerSetSummary) float64 { return ws.MinMaxTput.Max }},
+	{"ttotmin", 1, func(ws *WorkerSetSummary) float64 { return ws.MinMaxTput.Min }},
+	{"tp5", 1, func(ws *WorkerSetSummary) float64 { return ws.TputDist.P5 }},
+	{"tp50", 1, func(ws *WorkerSetSummary) float64 { return ws.TputDist.P50 }},
+	{"tcov", -1, func(ws *WorkerSetSummary) float64 { return ws.TputDist.CoV }},
+	{"trel", 1, func(ws *WorkerSetSummary) float64 { return ws.RelTput }},
+	{"utotal", 0, func(ws *WorkerSetSummary) float64 { return ws.TotalUtil }},
+	{"uavgavg", 0, func(ws *WorkerSetSummary) float64 { return ws.AvgAvgUtil }},
+	{"ustdev", 0, func(ws *WorkerSetSummary) float64 { return ws.AvgStdDevUtil }},
+	{"uavgmax", 0, func(ws *WorkerSetSummary) float64 { return ws.MinMaxAvgUtil.Max }},
+	{"uavgmin", 0, func(ws *WorkerSetSummary) float64 { return ws.MinMaxAvgUtil.Min }},
+	{"utotmax", 0, func(ws *WorkerSetSummary) float64 { return ws.MinMaxUtil.Max }},
+	{"utotmin", 0, func(ws *WorkerSetSummary) float64 { return ws.MinMaxUtil.Min }},
+	{"up5", 0, func(ws *WorkerSetSummary) float64 { return ws.UtilDist.P5 }},
+	{"up50", 0, func(ws *WorkerSetSummary) float64 { return ws.UtilDist.P50 }},
+	{"ucov", 0, func(ws *WorkerSetSummary) float64 { return ws.UtilDist.CoV }},
+	{"rwaitavg", -1, func(ws *WorkerSetSummary) float64 { return ws.AvgRunnableFrac }},
+	{"fshare", 0, func(ws *WorkerSetSummary) float64 { return ws.FairShare }},
+	{"fdevavg", 0, func(ws *WorkerSetSummary) float64 { return ws.AvgFairDev }},
+	{"fdevmax", -1, func(ws *WorkerSetSummary) float64 { return ws.MaxFairDev }},
+	{"jain", 1, func(ws *WorkerSetSummary) float64 { return ws.JainIndex }},
+	{"tdegr", 1, func(ws *WorkerSetSummary) float64 { return ws.Degradation }},
+	{"texpdegr", 0, func(ws *WorkerSetSummary) float64 { return ws.ExpectedDegradation }},
+	{"tratio", 1, func(ws *WorkerSetSummary) float64 { return ws.TputRatio }},
+}
+
+// The set metrics shown in summary tables of a run
+var SummarySetMetrics = []string{"ttotal", "tavgavg", "tstdev", "tavgmin", "tavgmax",
+	"ttotmin", "ttotmax", "tp5", "tp50", "trel", "utotal", "uavgavg", "ustdev",
+	"rwaitavg", "jain", "tratio"}
+
+// The indexes in SetMetrics of the named metrics which are non-zero
+// for at least one of the sets
+func UsedSetMetrics(names []string, sets []WorkerSetSummary) (metrics []int) {
+	for _, name := range names {
+		m := findSetMetric(name)
+		if m < 0 {
+			continue
+		}
+		for set := range sets {
+			if SetMetrics[m].Value(&sets[set]) != 0 {
+				metrics = append(metrics, m)
+				break
+			}
+		}
+	}
+	return
+}
+
+type MetricStats struct {
+	N int
+	Mean float64
+	StdDev float64
+	CILow float64
+	CIHigh float64
+}
+
+func NewMetricStats(x []float64) (ms MetricStats) {
+	ms.N = len(x)
+	if ms.N == 0 {
+		return
+	}
+	ms.Mean = Mean(x)
+	ms.StdDev = SampleStdDev(x)
+	ms.CILow, ms.CIHigh = BootstrapCI(x)
+	return
+}
+
+// All the completed runs of one configuration
+type RunGroup struct {
+	Key string
+	Label string
+	Runs []int
+	// Indexed by set, then by SetMetrics
+	Sets [][]MetricStats
+}
+
+// Group the completed runs by configuration, in the order in which
+// each configuration first appears.  Must be called after Process.
+func (plan *BenchmarkPlan) GroupRuns() (groups []RunGroup) {
+	index := make(map[string]int)
+	for i := range plan.Runs {
+		r := &plan.Runs[i]
+		if !r.Completed {
+			continue
+		}
+		key := r.ConfigKey()
+		g, ok := index[key]
+		if !ok {
+			g = len(groups)
+			index[key] = g
+			label := strings.TrimSuffix(r.Label, fmt.Sprintf(" #%d", r.Repeat))
+			groups = append(groups, RunGroup{Key:key, Label:label})
+		}
+		groups[g].Runs = append(groups[g].Runs, i)
+	}
+
+	for g := range groups {
+		grp := &groups[g]
+		sets := len(plan.Runs[grp.Runs[0]].Results.Summary)
+		grp.Sets = make([][]MetricStats, sets)
+		for set := range grp.Sets {
+			grp.Sets[set] = make([]MetricStats, len(SetMetrics))
+			for m := range SetMetrics {
+				var x []float64
+				for _, i := range grp.Runs {
+					x = append(x, SetMetrics[m].Value(&plan.Runs[i].Results.Summary[set]))
+				}
+				grp.Sets[set][m] = NewMetricStats(x)
+			}
+		}
+	}
+	return
+}
+
+func (plan *BenchmarkPlan) GroupReport(level int) (err error) {
+	err = plan.Process()
+	if err != nil {
+		return
+	}
+
+	for _, grp := range plan.GroupRuns() {
+		fmt.Printf("== GROUP %s (%d runs) ==\n", grp.Label, len(grp.Runs))
+		if level >= 1 {
+			fmt.Printf("%s\n", grp.Key)
+		}
+		fmt.Printf("%8s %8s %8s %8s %8s %8s\n", "set", "metric", "mean", "stdev", "ci95lo", "ci95hi")
+		for set := range grp.Sets {
+			for m := range SetMetrics {
+				ms := &grp.Sets[set][m]
+				// Metrics which weren't calculated for this plan
+				if ms.Mean == 0 && ms.StdDev == 0 {
+					continue
+				}
+				fmt.Printf("%8d %8s %8.2f %8.2f %8.2f %8.2f\n",
+					set, SetMetrics[m].Name, ms.Mean, ms.StdDev, ms.CILow, ms.CIHigh)
+			}
+		}
+		fmt.Printf("\n")
+	}
+	return
+}
@@ -0,0 +1,121 @@
+/*
+ * Copyright (C) 2016 George W. Dunlap, Citrix Systems UK Ltd
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation; version 2 of the
+ * License only.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301, USA.
+ */
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strings"
+)
+
+func fileSha256(filename string) (sum string, err error) {
+	var b []byte
+	b, err = ioutil.ReadFile(filename)
+	if err != nil {
+		return
+	}
+	sum = fmt.Sprintf("%x", sha256.Sum256(b))
+	return
+}
+
+func cpuModel() (model string) {
+	b, err := ioutil.ReadFile("/proc/cpuinfo")
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		f := strings.SplitN(line, ":", 2)
+		if len(f) == 2 && strings.TrimSpace(f[0]) == "model name" {
+			model = strings.TrimSpace(f[1])
+			return
+		}
+	}
+	return
+}
+
+// Find out as much as we can about where this run is being done.
+// Nothing here is fatal; anything we can't find out is left empty.
+func (run *BenchmarkRun) CollectHostInfo(workerType int) (h *HostInfo) {
+	h = &HostInfo{
+		ControllerVersion:Version,
+		CpuModel:cpuModel(),
+		KHZ:run.Results.KHZ,
+		KHZMethod:run.Results.KHZMethod,
+	}
+	h.Hostname, _ = os.Hostname()
+
+	switch workerType {
+	case WorkerProcess:
+		h.Cpus = runtime.NumCPU()
+		h.WorkerImage = "worker-proc"
+		h.PoolCpus = run.RunConfig.Cpus
+	case WorkerXen:
+		h.WorkerImage = "worker-xen.img"
+
+		if physinfo, err := Ctx.GetPhysinfo(); err == nil {
+			h.Cpus = int(physinfo.NrCpus)
+			h.ThreadsPerCore = int(physinfo.ThreadsPerCore)
+			h.CoresPerSocket = int(physinfo.CoresPerSocket)
+			h.Nodes = int(physinfo.NrNodes)
+			// Pages are 4k
+			h.MemoryMB = physinfo.TotalPages * 4 / 1024
+		}
+
+		if info, err := Ctx.GetVersionInfo(); err == nil {
+			h.XenVersion = fmt.Sprintf("%d.%d%s", info.XenVersionMajor,
+				info.XenVersionMinor, info.XenVersionExtra)
+			h.XenChangeset = info.Changeset
+			h.XenCommandline = info.Commandline
+		}
+
+		if di, err := Ctx.DomainInfo(0); err == nil {
+			h.Dom0Vcpus = int(di.Vcpu_online)
+		}
+
+		h.Pool = run.RunConfig.Pool
+		if h.Pool == "" {
+			h.Pool = "Pool-0"
+		}
+		if pool, found := Ctx.CpupoolFindByName(h.Pool); found {
+			h.PoolScheduler = pool.Scheduler.String()
+			for i := 0; i <= pool.Cpumap.Max(); i++ {
+				if pool.Cpumap.Test(i) {
+					h.PoolCpus = append(h.PoolCpus, i)
+				}
+			}
+			// FIXME: credit2 parameters, once libxl has them
+			if pool.Scheduler == SchedulerCredit {
+				if params, err := Ctx.SchedCreditParamsGet(pool.Poolid); err == nil {
+					h.SchedParams = map[string]int{
+						"tslice_ms":params.TsliceMs,
+						"ratelimit_us":params.RatelimitUs,
+					}
+				}
+			}
+		}
+	}
+
+	if sum, err := fileSha256(h.WorkerImage); err == nil {
+		h.WorkerSha256 = sum
+	}
+	return
+}
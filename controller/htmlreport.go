@@ -22,23 +22,11 @@ import (
 	"fmt"
 	"os"
 	"io"
-	"encoding/json"
+	"html"
+	"math"
+	"strings"
 )
 
-type OptionAxis struct {
-	Title string `json:"title,omitempty"`
-	// Always include this one so that we can start graphs at 0
-	MinValue float64 `json:"minValue"`
-	MaxValue float64 `json:"maxValue,omitempty"`
-}
-
-type Options struct {
-	Title string     `json:"title,omitempty"`
-	HAxis OptionAxis `json:"hAxis"`
-	VAxis OptionAxis `json:"vAxis"`
-	Legend string    `json:"legend,omitempty"`
-}
-
 type Point struct {
 	x float64
 	y float64
@@ -52,123 +40,221 @@ type RunRaw struct {
 	Points [][]Point
 }
 
-func (options *Options) OutputJavascript(w io.Writer, tag string) (err error) {
-	var optionsJson []byte
-	optionsJson, err = json.Marshal(options)
-	if err != nil {
-		return
+func (d *RunRaw) OutputHTML(w io.Writer) (err error) {
+	chart := SVGChart{
+		Title: d.Title,
+		hTitle: d.hTitle,
+		vTitle: d.vTitle,
+		Series: d.Points,
 	}
-
-	fmt.Fprintf(w, "        var %sopt = ", tag)
-	fmt.Fprint(w, string(optionsJson))
-	fmt.Fprintln(w, ";")
-
+	for i := range d.Points {
+		chart.Names = append(chart.Names, fmt.Sprintf("Worker %d", i))
+	}
+	fmt.Fprintf(w, "    <div class='scatterplot' id='%s'>\n", d.Tag)
+	err = chart.Output(w)
+	fmt.Fprintf(w, "    </div>\n")
 	return
 }
 
-func (p *Point) OutputJson(w io.Writer, id int, max int) (err error) {
-	fmt.Fprintf(w, "            [%f", p.x)
-	for i := 0; i < max; i++ {
-		if i == id {
-			fmt.Fprintf(w, ", %f", p.y)
-		} else {
-			fmt.Fprintf(w, ", null")
+// Lines through the same x values; NaN where a line has no point
+type LineChart struct {
+	Tag string
+	Title string
+	hTitle string
+	vTitle string
+	Names []string
+	X []float64
+	Y [][]float64
+}
+
+func (c *LineChart) OutputHTML(w io.Writer) (err error) {
+	chart := SVGChart{
+		Title: c.Title,
+		hTitle: c.hTitle,
+		vTitle: c.vTitle,
+		Names: c.Names,
+		Lines: true,
+	}
+	for l := range c.Y {
+		var s []Point
+		for i, x := range c.X {
+			s = append(s, Point{x:x, y:c.Y[l][i]})
 		}
+		chart.Series = append(chart.Series, s)
 	}
-	fmt.Fprint(w, "],\n")
+	fmt.Fprintf(w, "    <div class='scatterplot' id='%s'>\n", c.Tag)
+	err = chart.Output(w)
+	fmt.Fprintf(w, "    </div>\n")
 	return
 }
 
-func (d *RunRaw) OutputHTML(w io.Writer) (err error) {
-	fmt.Fprintf(w, "    <div class='scatterplot' id='scatterplot%s'></div>\n", d.Tag)
-	return
+// Bars grouped by Groups, one per name in each group; NaN where a
+// name has no value
+type BarChart struct {
+	Tag string
+	Title string
+	vTitle string
+	Names []string
+	Groups []string
+	// By name, then by group
+	Y [][]float64
 }
 
-func (d *RunRaw) OutputJavascript(w io.Writer) (err error) {
-	var options Options
-
-	options.Title = d.Title
-	options.HAxis.Title = d.hTitle
-	options.VAxis.Title = d.vTitle
-
-	err = options.OutputJavascript(w, d.Tag)
-	if err != nil {
-		return
+func (c *BarChart) OutputHTML(w io.Writer) (err error) {
+	chart := SVGChart{
+		Title: c.Title,
+		vTitle: c.vTitle,
+		Names: c.Names,
+		Bars: true,
+		Groups: c.Groups,
 	}
-
-	fmt.Printf("        var %sdata = new google.visualization.DataTable();\n", d.Tag)
-	fmt.Printf("        %sdata.addColumn('number', 'Time');\n", d.Tag)
-	for i := range d.Points {
-		fmt.Printf("        %sdata.addColumn('number', 'Worker %d');\n", d.Tag, i)
+	for n := range c.Y {
+		var s []Point
+		for g, y := range c.Y[n] {
+			s = append(s, Point{x:float64(g), y:y})
+		}
+		chart.Series = append(chart.Series, s)
 	}
-	fmt.Printf("        %sdata.addRows([\n", d.Tag)
+	fmt.Fprintf(w, "    <div class='scatterplot' id='%s'>\n", c.Tag)
+	err = chart.Output(w)
+	fmt.Fprintf(w, "    </div>\n")
+	return
+}
 
-	// Can't use json here because we need to be able to use 'null' for non-existent values
-	for i := range d.Points {
-		for j := range d.Points[i] {
-			err = d.Points[i][j].OutputJson(w, i, len(d.Points))
-			if err != nil {
-				return
+type HTMLTable struct {
+	Title string
+	Header []string
+	Rows [][]string
+	// If set, the first cell of each row links here
+	Links []string
+}
+
+func (t *HTMLTable) OutputHTML(w io.Writer) (err error) {
+	fmt.Fprintf(w, "    <table class='summary'>\n")
+	fmt.Fprintf(w, "      <caption>%s</caption>\n", html.EscapeString(t.Title))
+	fmt.Fprintf(w, "      <tr>")
+	for _, h := range t.Header {
+		fmt.Fprintf(w, "<th>%s</th>", html.EscapeString(h))
+	}
+	fmt.Fprintf(w, "</tr>\n")
+	for r, row := range t.Rows {
+		fmt.Fprintf(w, "      <tr>")
+		for i, c := range row {
+			if i == 0 && r < len(t.Links) {
+				fmt.Fprintf(w, "<td><a href='%s'>%s</a></td>", html.EscapeString(t.Links[r]),
+					html.EscapeString(c))
+				continue
 			}
+			fmt.Fprintf(w, "<td>%s</td>", html.EscapeString(c))
 		}
+		fmt.Fprintf(w, "</tr>\n")
 	}
-	fmt.Print("          ]);\n")
-	
-	fmt.Printf("        var %schart = new google.visualization.ScatterChart(document.getElementById('scatterplot%s'));\n", d.Tag, d.Tag);
-	fmt.Printf("        %schart.draw(%sdata, %sopt);\n\n", d.Tag, d.Tag, d.Tag)
-	
+	fmt.Fprintf(w, "    </table>\n")
 	return
 }
 
+// Anything which can go in a section of the report
+type HTMLItem interface {
+	OutputHTML(w io.Writer) error
+}
+
+type HTMLSection struct {
+	Id string
+	Title string
+	Items []HTMLItem
+}
+
 type HTMLReport struct {
-	Raw []RunRaw
+	Sections []HTMLSection
+	// Charts are tagged in order across the whole report
+	charts int
+}
+
+func (rpt *HTMLReport) AddSection(id string, title string) (sec *HTMLSection) {
+	rpt.Sections = append(rpt.Sections, HTMLSection{Id:id, Title:title})
+	return &rpt.Sections[len(rpt.Sections)-1]
+}
+
+func (rpt *HTMLReport) tag() (tag string) {
+	tag = fmt.Sprintf("chart%d", rpt.charts)
+	rpt.charts++
+	return
 }
 
+// Links to the top, and the sections either side
+func (rpt *HTMLReport) outputNav(w io.Writer, i int) {
+	fmt.Fprintf(w, "    <div class='nav'><a href='#top'>top</a>")
+	if i > 0 {
+		fmt.Fprintf(w, " | <a href='#%s'>previous</a>", rpt.Sections[i-1].Id)
+	}
+	if i + 1 < len(rpt.Sections) {
+		fmt.Fprintf(w, " | <a href='#%s'>next</a>", rpt.Sections[i+1].Id)
+	}
+	fmt.Fprintf(w, "</div>\n")
+}
 
 func (rpt *HTMLReport) Output(w io.Writer) (err error) {
-	// Print start -> json charts
 	fmt.Fprint(w,
 		`<html>
   <head>
+    <meta charset="utf-8">
     <style>
       .scatterplot {
-      margin:auto;
-      width: 100vw;
-      height: 60vw;
+      margin: auto;
+      width: 90vw;
+      }
+
+      .chart {
+      width: 100%;
+      height: auto;
+      font-family: sans-serif;
       }
 
       .empty {
       margin: auto;
       }
+
+      .summary {
+      margin: 1em auto;
+      border-collapse: collapse;
+      }
+
+      .summary td, .summary th {
+      border: 1px solid #ccc;
+      padding: 0.2em 0.5em;
+      text-align: right;
+      }
+
+      .nav {
+      text-align: center;
+      font-family: sans-serif;
+      }
+
+      h2 {
+      text-align: center;
+      font-family: sans-serif;
+      }
     </style>
-    <script type="text/javascript" src="https://www.gstatic.com/charts/loader.js"></script>
-    <script type="text/javascript">
-      google.charts.load('current', {'packages':['corechart']});
-      google.charts.setOnLoadCallback(drawCharts);
-      function drawCharts() {
-`);
-	// Print json chart code
-	for i := range rpt.Raw {
-		err = rpt.Raw[i].OutputJavascript(w)
-		if err != nil {
-			return
-		}
-	}
-	// Print json -> html
-	fmt.Fprint(w,
-		`      }
-    </script>
   </head>
   <body>
+    <div class='nav' id='top'>
 `);
-	// Print html
-	for i := range rpt.Raw {
-		err = rpt.Raw[i].OutputHTML(w)
-		if err != nil {
-			return
+	for i := range rpt.Sections {
+		sec := &rpt.Sections[i]
+		fmt.Fprintf(w, "      <a href='#%s'>%s</a><br>\n", sec.Id, html.EscapeString(sec.Title))
+	}
+	fmt.Fprintf(w, "    </div>\n")
+	for i := range rpt.Sections {
+		sec := &rpt.Sections[i]
+		fmt.Fprintf(w, "    <h2 id='%s'>%s</h2>\n", sec.Id, html.EscapeString(sec.Title))
+		rpt.outputNav(w, i)
+		for _, item := range sec.Items {
+			err = item.OutputHTML(w)
+			if err != nil {
+				return
+			}
 		}
 	}
-	// Print html -> end
 	fmt.Fprint(w,
 		`  </body>
 </html>
@@ -176,7 +262,62 @@ func (rpt *HTMLReport) Output(w io.Writer) (err error) {
 	return
 }
 
-func (rpt *HTMLReport) AddRun(run *BenchmarkRun) (err error) {
+// A table of the metrics of each set, leaving out ones which weren't
+// calculated
+func setSummaryTable(title string, sets []WorkerSetSummary) (t HTMLTable) {
+	t = HTMLTable{Title: title, Header: []string{"Set"}}
+	metrics := UsedSetMetrics(SummarySetMetrics, sets)
+	for _, m := range metrics {
+		t.Header = append(t.Header, SetMetrics[m].Name)
+	}
+	for set := range sets {
+		row := []string{fmt.Sprintf("%d", set)}
+		for _, m := range metrics {
+			row = append(row, fmt.Sprintf("%.2f", SetMetrics[m].Value(&sets[set])))
+		}
+		t.Rows = append(t.Rows, row)
+	}
+	return
+}
+
+func runSectionId(i int) string {
+	return fmt.Sprintf("run%d", i)
+}
+
+func (rpt *HTMLReport) AddRun(i int, run *BenchmarkRun) (err error) {
+	sec := rpt.AddSection(runSectionId(i), fmt.Sprintf("Run %s", run.Label))
+
+	config := HTMLTable{Title: "Configuration", Header: []string{"", ""}}
+	for a := range RunAxes {
+		config.Rows = append(config.Rows, []string{RunAxes[a].Name, RunAxes[a].Value(run)})
+	}
+	for set := range run.WorkerSets {
+		config.Rows = append(config.Rows, []string{fmt.Sprintf("set %d", set),
+			strings.Join(run.WorkerSets[set].Params.Args, " ")})
+	}
+	if h := run.Results.Host; h != nil {
+		config.Rows = append(config.Rows, []string{"host", h.Identity()})
+	}
+	if run.Results.StopReason != "" {
+		config.Rows = append(config.Rows, []string{"stopped", run.Results.StopReason})
+	}
+	sec.Items = append(sec.Items, &config)
+
+	if !run.Completed {
+		return
+	}
+
+	summary := setSummaryTable("Summary", run.Results.Summary)
+	if _, rwaitavg := run.rwaitNames(); rwaitavg != "rwaitavg" {
+		for c := range summary.Header {
+			if summary.Header[c] == "rwaitavg" {
+				summary.Header[c] = rwaitavg
+			}
+		}
+		summary.Title += " (" + RunstateEstimateNote + ")"
+	}
+	sec.Items = append(sec.Items, &summary)
+
 	var tPut RunRaw
 	var Util RunRaw
 
@@ -205,34 +346,240 @@ func (rpt *HTMLReport) AddRun(run *BenchmarkRun) (err error) {
 		tPut.Points = append(tPut.Points, idTput)
 		Util.Points = append(Util.Points, idUtil)
 	}
-	tPut.Tag = fmt.Sprintf("raw%d", len(rpt.Raw))
-	rpt.Raw = append(rpt.Raw, tPut)
-	Util.Tag = fmt.Sprintf("raw%d", len(rpt.Raw))
-	rpt.Raw = append(rpt.Raw, Util)
+	if run.Results.JainIndex > 0 {
+		t := HTMLTable{
+			Title: fmt.Sprintf("Run %s Fairness (Jain's index %.3f)", run.Label, run.Results.JainIndex),
+			Header: []string{"Worker", "Fair share", "Utilization", "Deviation"},
+		}
+		for set := range run.Results.Summary {
+			ws := &run.Results.Summary[set]
+			for id := range ws.Workers {
+				s := &ws.Workers[id]
+				t.Rows = append(t.Rows, []string{
+					fmt.Sprintf("%d:%d", set, id),
+					fmt.Sprintf("%.2f", s.FairShare),
+					fmt.Sprintf("%.2f", s.AvgUtil),
+					fmt.Sprintf("%+.1f%%", s.FairDev * 100),
+				})
+			}
+			t.Rows = append(t.Rows, []string{
+				fmt.Sprintf("Set %d", set),
+				fmt.Sprintf("%.2f", ws.FairShare),
+				fmt.Sprintf("%.2f", ws.AvgAvgUtil),
+				fmt.Sprintf("%+.1f%% (max %.1f%%, Jain %.3f)",
+					ws.AvgFairDev * 100, ws.MaxFairDev * 100, ws.JainIndex),
+			})
+		}
+		sec.Items = append(sec.Items, &t)
+	}
+
+	tPut.Tag = rpt.tag()
+	Util.Tag = rpt.tag()
+	sec.Items = append(sec.Items, &tPut, &Util)
 	return
 }
 
-func (plan *BenchmarkPlan) HTMLReport() (err error) {
+// A row per run, linking to its section
+func (rpt *HTMLReport) AddOverview(plan *BenchmarkPlan) {
+	sec := rpt.AddSection("overview", "Overview")
+
+	if hosts := plan.Hosts(); len(hosts) > 0 {
+		t := HTMLTable{Title: "Hosts", Header: []string{"Host"}}
+		if len(hosts) > 1 {
+			t.Title = fmt.Sprintf("Hosts (WARNING: %d different hosts or builds)", len(hosts))
+		}
+		for _, h := range hosts {
+			t.Rows = append(t.Rows, []string{h})
+		}
+		sec.Items = append(sec.Items, &t)
+	}
+
+	t := HTMLTable{Title: "Runs", Header: []string{"Run", "Workers", "Total throughput",
+		"Total utilization", "Jain", "Overhead", "Anomalies", "Stopped"}}
+	for i := range plan.Runs {
+		r := &plan.Runs[i]
+		row := []string{r.Label, fmt.Sprintf("%d", r.WorkerCount())}
+		if !r.Completed {
+			row = append(row, "not run", "", "", "", "", "")
+		} else {
+			var tput, util float64
+			for set := range r.Results.Summary {
+				tput += r.Results.Summary[set].TotalTput
+				util += r.Results.Summary[set].TotalUtil
+			}
+			row = append(row, fmt.Sprintf("%.2f", tput), fmt.Sprintf("%.2f", util))
+			if r.Results.JainIndex > 0 {
+				row = append(row, fmt.Sprintf("%.3f", r.Results.JainIndex))
+			} else {
+				row = append(row, "")
+			}
+			if o := r.Results.Overhead; o != nil {
+				row = append(row, fmt.Sprintf("%.1f%%", o.OverheadFrac * 100))
+			} else {
+				row = append(row, "")
+			}
+			row = append(row, fmt.Sprintf("%d", len(r.Results.Anomalies)), r.Results.StopReason)
+		}
+		t.Rows = append(t.Rows, row)
+		t.Links = append(t.Links, "#" + runSectionId(i))
+	}
+	sec.Items = append(sec.Items, &t)
+}
+
+// The metrics of each set of the same worker mix, with a bar for
+// each scheduler and NUMA setting
+var HTMLCompareMetrics = []string{"tavgavg", "uavgavg", "jain"}
+
+func (rpt *HTMLReport) AddComparisons(plan *BenchmarkPlan) {
+	type Mix struct {
+		key string
+		variants []string
+		groups []*RunGroup
+	}
+	var mixes []*Mix
+	index := make(map[string]*Mix)
+
+	groups := plan.GroupRuns()
+	for g := range groups {
+		grp := &groups[g]
+		r := &plan.Runs[grp.Runs[0]]
+		if r.Baseline {
+			continue
+		}
+		key := r.ConfigKey("scheduler", "numa")
+		mix := index[key]
+		if mix == nil {
+			mix = &Mix{key:key}
+			index[key] = mix
+			mixes = append(mixes, mix)
+		}
+		sched, _ := FindAxis("scheduler")
+		numa, _ := FindAxis("numa")
+		variant := sched.Value(r)
+		if n := numa.Value(r); n != "" {
+			variant = fmt.Sprintf("%s numa %s", variant, n)
+		}
+		mix.variants = append(mix.variants, variant)
+		mix.groups = append(mix.groups, grp)
+	}
+
+	var sec *HTMLSection
+	for _, mix := range mixes {
+		if len(mix.groups) < 2 {
+			continue
+		}
+		if sec == nil {
+			sec = rpt.AddSection("comparisons", "Scheduler comparisons")
+		}
+		sets := len(mix.groups[0].Sets)
+		for _, name := range HTMLCompareMetrics {
+			m := findSetMetric(name)
+			chart := BarChart{
+				Tag: rpt.tag(),
+				Title: fmt.Sprintf("%s: %s", mix.key, name),
+				vTitle: name,
+				Names: mix.variants,
+			}
+			any := false
+			for set := 0; set < sets; set++ {
+				chart.Groups = append(chart.Groups, fmt.Sprintf("Set %d", set))
+			}
+			for _, grp := range mix.groups {
+				var y []float64
+				for set := 0; set < sets; set++ {
+					v := math.NaN()
+					if set < len(grp.Sets) {
+						v = grp.Sets[set][m].Mean
+						if v != 0 {
+							any = true
+						}
+					}
+					y = append(y, v)
+				}
+				chart.Y = append(chart.Y, y)
+			}
+			if any {
+				sec.Items = append(sec.Items, &chart)
+			}
+		}
+	}
+}
+
+// Throughput, utilization and fairness against the number of
+// workers, one line per scheduler
+func (rpt *HTMLReport) AddScaling(plan *BenchmarkPlan) {
+	var sec *HTMLSection
+	for _, curve := range plan.ScalingCurves() {
+		if len(curve.Workers) < 2 {
+			continue
+		}
+		if sec == nil {
+			sec = rpt.AddSection("scaling", "Scaling")
+		}
+		var x []float64
+		for _, n := range curve.Workers {
+			x = append(x, float64(n))
+		}
+		for set := 0; set < curve.Sets; set++ {
+			for _, name := range ScalingMetrics {
+				m := findSetMetric(name)
+				y, ok := curve.Series(set, m)
+				if !ok {
+					continue
+				}
+				sec.Items = append(sec.Items, &LineChart{
+					Tag: rpt.tag(),
+					Title: fmt.Sprintf("%s: set %d %s", curve.Key, set, name),
+					hTitle: "Workers",
+					vTitle: name,
+					Names: curve.Schedulers,
+					X: x,
+					Y: y,
+				})
+			}
+		}
+	}
+}
+
+// Write the report to the named file, or stdout if it's empty
+func (plan *BenchmarkPlan) HTMLReport(output string) (err error) {
 	rpt := HTMLReport{}
 
+	err = plan.Process()
+	if err != nil {
+		return
+	}
+
+	rpt.AddOverview(plan)
+	rpt.AddComparisons(plan)
+	rpt.AddScaling(plan)
+
 	for i := range plan.Runs {
 		r := &plan.Runs[i]
 		if ! r.Completed {
-			fmt.Printf("Test [%d] %s not run\n", i, r.Label)
+			// stdout may be the report
+			fmt.Fprintf(os.Stderr, "Test [%d] %s not run\n", i, r.Label)
 		}
 
-		err = r.Process()
+		err = rpt.AddRun(i, r)
 		if err != nil {
-			fmt.Printf("Error processing [%d] %s: %v\n", i, r.Label, err)
 			return
 		}
+	}
 
-		err = rpt.AddRun(r)
-		if err != nil {
-			return
-		}
+	if output == "" {
+		err = rpt.Output(os.Stdout)
+		return
 	}
-	err = rpt.Output(os.Stdout)
 
+	var f *os.File
+	f, err = os.Create(output)
+	if err != nil {
+		return
+	}
+	err = rpt.Output(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
 	return
 }
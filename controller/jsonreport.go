@@ -0,0 +1,366 @@
+/*
+ * Copyright (C) 2016 George W. Dunlap, Citrix Systems UK Ltd
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation; version 2 of the
+ * License only.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301, USA.
+ */
+package main
+
+/*
+ * The processed results as JSON, for other tools.  Unlike the .bench
+ * file, whose layout follows the code, this is a documented schema
+ * (see README.md): fields may be added, but not renamed or removed,
+ * without bumping JSONReportSchema.
+ */
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"os"
+)
+
+const JSONReportSchema = 1
+
+type JSONDistribution struct {
+	P1 float64  `json:"p1"`
+	P5 float64  `json:"p5"`
+	P50 float64 `json:"p50"`
+	P95 float64 `json:"p95"`
+	P99 float64 `json:"p99"`
+	IQR float64 `json:"iqr"`
+	CoV float64 `json:"cov"`
+}
+
+func jsonDistribution(d *Distribution) JSONDistribution {
+	return JSONDistribution{P1:d.P1, P5:d.P5, P50:d.P50, P95:d.P95, P99:d.P99, IQR:d.IQR, CoV:d.CoV}
+}
+
+// Metrics by name; a metric which can't be computed (say, a ratio to
+// zero) is null, since JSON has no NaN or infinity
+type JSONMetrics map[string]*float64
+
+type JSONWorker struct {
+	Id int                     `json:"id"`
+	// Named as in WorkerColumns
+	Metrics JSONMetrics        `json:"metrics"`
+	Tput JSONDistribution      `json:"tput"`
+	Util JSONDistribution      `json:"util"`
+	Rwait *JSONDistribution    `json:"rwait,omitempty"`
+}
+
+type JSONSet struct {
+	Set int                    `json:"set"`
+	Preset string              `json:"preset,omitempty"`
+	Count int                  `json:"count"`
+	Args []string              `json:"args"`
+	PairWith *int              `json:"pairWith,omitempty"`
+	// The rest only for completed runs.  Named as in SetMetrics.
+	Metrics JSONMetrics        `json:"metrics,omitempty"`
+	Tput *JSONDistribution     `json:"tput,omitempty"`
+	Util *JSONDistribution     `json:"util,omitempty"`
+	Rwait *JSONDistribution    `json:"rwait,omitempty"`
+	// A pointer, so that a completed run's set with no workers
+	// still has an (empty) list
+	Workers *[]JSONWorker      `json:"workers,omitempty"`
+}
+
+type JSONHost struct {
+	Hostname string          `json:"hostname"`
+	ControllerVersion string `json:"controllerVersion"`
+	CpuModel string          `json:"cpuModel"`
+	Cpus int                 `json:"cpus"`
+	ThreadsPerCore int       `json:"threadsPerCore,omitempty"`
+	CoresPerSocket int       `json:"coresPerSocket,omitempty"`
+	Nodes int                `json:"nodes,omitempty"`
+	MemoryMB uint64          `json:"memoryMB,omitempty"`
+	XenVersion string        `json:"xenVersion,omitempty"`
+	XenChangeset string      `json:"xenChangeset,omitempty"`
+	XenCommandline string    `json:"xenCommandline,omitempty"`
+	Dom0Vcpus int            `json:"dom0Vcpus,omitempty"`
+	Pool string              `json:"pool,omitempty"`
+	PoolScheduler string     `json:"poolScheduler,omitempty"`
+	PoolCpus []int           `json:"poolCpus,omitempty"`
+	SchedParams map[string]int `json:"schedParams,omitempty"`
+	WorkerImage string       `json:"workerImage"`
+	WorkerSha256 string      `json:"workerSha256"`
+	Identity string          `json:"identity"`
+}
+
+// Like metrics, the derived values below are null if they couldn't be
+// computed
+type JSONPool struct {
+	Cpus []int           `json:"cpus"`
+	IdleFrac *float64    `json:"idleFrac"`
+	CpuIdleFrac []*float64 `json:"cpuIdleFrac"`
+	Intervals int        `json:"intervals"`
+	Violations int       `json:"violations"`
+	WastedCpu *float64   `json:"wastedCpu"`
+}
+
+type JSONOverhead struct {
+	Capacity *float64     `json:"capacity"`
+	Idle *float64         `json:"idle,omitempty"`
+	Accounted *float64    `json:"accounted"`
+	Unaccounted *float64  `json:"unaccounted"`
+	OverheadFrac *float64 `json:"overheadFrac"`
+	// Null without a calibration
+	Useful *float64       `json:"useful"`
+	Efficiency *float64   `json:"efficiency"`
+}
+
+type JSONAnomaly struct {
+	Worker string   `json:"worker"`
+	Kind string     `json:"kind"`
+	Window int      `json:"window"`
+	Value float64   `json:"value"`
+	Excluded bool   `json:"excluded"`
+}
+
+type JSONRun struct {
+	Index int                 `json:"index"`
+	Label string              `json:"label"`
+	Baseline bool             `json:"baseline"`
+	Repeat int                `json:"repeat"`
+	Completed bool            `json:"completed"`
+	// Named as in RunAxes
+	Axes map[string]string    `json:"axes"`
+	Key string                `json:"key"`
+	RuntimeSeconds int        `json:"runtimeSeconds"`
+	StopReason string         `json:"stopReason,omitempty"`
+	KHZ uint64                `json:"kHZ,omitempty"`
+	KHZMethod string          `json:"kHZMethod,omitempty"`
+	Host *JSONHost            `json:"host,omitempty"`
+	// Null without a baseline
+	Jain *float64             `json:"jain"`
+	Pool *JSONPool            `json:"pool,omitempty"`
+	Overhead *JSONOverhead    `json:"overhead,omitempty"`
+	// rwait was estimated by sampling the vcpu states (Xen)
+	RwaitEstimated bool       `json:"rwaitEstimated,omitempty"`
+	Anomalies []JSONAnomaly   `json:"anomalies"`
+	Sets []JSONSet            `json:"sets"`
+}
+
+type JSONPreset struct {
+	Tput float64     `json:"tput"`
+	NsPerKop float64 `json:"nsPerKop"`
+	DutyCycle float64 `json:"dutyCycle"`
+}
+
+type JSONCalibration struct {
+	NsPerKop float64              `json:"nsPerKop"`
+	Presets map[string]JSONPreset `json:"presets"`
+}
+
+type JSONScheduler struct {
+	Scheduler string `json:"scheduler"`
+	Sets int         `json:"sets"`
+	Score *float64   `json:"score"`
+	Worst *float64   `json:"worst"`
+}
+
+type JSONReport struct {
+	Schema int                   `json:"schema"`
+	Plan string                  `json:"plan"`
+	ExcludeAnomalies bool        `json:"excludeAnomalies"`
+	Calibration *JSONCalibration `json:"calibration,omitempty"`
+	Hosts []string               `json:"hosts"`
+	Runs []JSONRun               `json:"runs"`
+	Schedulers []JSONScheduler   `json:"schedulers"`
+}
+
+func jsonHost(h *HostInfo) *JSONHost {
+	return &JSONHost{
+		Hostname:h.Hostname,
+		ControllerVersion:h.ControllerVersion,
+		CpuModel:h.CpuModel,
+		Cpus:h.Cpus,
+		ThreadsPerCore:h.ThreadsPerCore,
+		CoresPerSocket:h.CoresPerSocket,
+		Nodes:h.Nodes,
+		MemoryMB:h.MemoryMB,
+		XenVersion:h.XenVersion,
+		XenChangeset:h.XenChangeset,
+		XenCommandline:h.XenCommandline,
+		Dom0Vcpus:h.Dom0Vcpus,
+		Pool:h.Pool,
+		PoolScheduler:h.PoolScheduler,
+		PoolCpus:h.PoolCpus,
+		SchedParams:h.SchedParams,
+		WorkerImage:h.WorkerImage,
+		WorkerSha256:h.WorkerSha256,
+		Identity:h.Identity(),
+	}
+}
+
+// Nil (null) if v isn't finite
+func jsonFloat(v float64) *float64 {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return nil
+	}
+	return &v
+}
+
+func (metrics JSONMetrics) set(name string, v float64) {
+	metrics[name] = jsonFloat(v)
+}
+
+func jsonRun(i int, run *BenchmarkRun) (jr JSONRun) {
+	jr = JSONRun{
+		Index:i,
+		Label:run.Label,
+		Baseline:run.Baseline,
+		Repeat:run.Repeat,
+		Completed:run.Completed,
+		Axes:make(map[string]string),
+		Key:run.ConfigKey(),
+		RuntimeSeconds:run.RuntimeSeconds,
+		StopReason:run.Results.StopReason,
+		KHZ:run.Results.KHZ,
+		KHZMethod:run.Results.KHZMethod,
+		Anomalies:[]JSONAnomaly{},
+		Sets:[]JSONSet{},
+	}
+	for a := range RunAxes {
+		jr.Axes[RunAxes[a].Name] = RunAxes[a].Value(run)
+	}
+	if run.Results.Host != nil {
+		jr.Host = jsonHost(run.Results.Host)
+	}
+	if run.Results.JainIndex != 0 {
+		jr.Jain = jsonFloat(run.Results.JainIndex)
+	}
+	if p := run.Results.Pool; p != nil {
+		jr.Pool = &JSONPool{Cpus:run.Results.PoolCpus, IdleFrac:jsonFloat(p.IdleFrac),
+			CpuIdleFrac:[]*float64{}, Intervals:p.Intervals,
+			Violations:p.Violations, WastedCpu:jsonFloat(p.WastedCpu)}
+		for _, f := range p.CpuIdleFrac {
+			jr.Pool.CpuIdleFrac = append(jr.Pool.CpuIdleFrac, jsonFloat(f))
+		}
+	}
+	if o := run.Results.Overhead; o != nil {
+		jr.Overhead = &JSONOverhead{Capacity:jsonFloat(o.Capacity), Accounted:jsonFloat(o.Accounted),
+			Unaccounted:jsonFloat(o.Unaccounted), OverheadFrac:jsonFloat(o.OverheadFrac)}
+		if o.IdleKnown {
+			jr.Overhead.Idle = jsonFloat(o.Idle)
+		}
+		if o.Useful > 0 {
+			jr.Overhead.Useful = jsonFloat(o.Useful)
+			jr.Overhead.Efficiency = jsonFloat(o.Efficiency)
+		}
+	}
+	// Every worker of a run not yet done looks missing
+	if run.Completed {
+		for _, a := range run.Results.Anomalies {
+			jr.Anomalies = append(jr.Anomalies, JSONAnomaly{Worker:a.Id.String(), Kind:a.Kind,
+				Window:a.Window, Value:a.Value, Excluded:a.Excluded})
+		}
+	}
+
+	showWait := run.hasRunstate()
+	jr.RwaitEstimated = run.runstateEstimated()
+	for set := range run.WorkerSets {
+		ws := &run.WorkerSets[set]
+		js := JSONSet{Set:set, Preset:ws.Preset, Count:ws.Count, Args:ws.Params.Args,
+			PairWith:ws.PairWith}
+		if js.Args == nil {
+			js.Args = []string{}
+		}
+		// Process makes an empty summary for runs which haven't
+		// been done; leave it out
+		if run.Completed && set < len(run.Results.Summary) {
+			s := &run.Results.Summary[set]
+			js.Metrics = make(JSONMetrics)
+			for m := range SetMetrics {
+				js.Metrics.set(SetMetrics[m].Name, SetMetrics[m].Value(s))
+			}
+			tput, util := jsonDistribution(&s.TputDist), jsonDistribution(&s.UtilDist)
+			js.Tput, js.Util = &tput, &util
+			workers := []JSONWorker{}
+			if showWait {
+				d := jsonDistribution(&s.RunnableDist)
+				js.Rwait = &d
+			}
+			for id := range s.Workers {
+				w := &s.Workers[id]
+				jw := JSONWorker{Id:id, Metrics:make(JSONMetrics),
+					Tput:jsonDistribution(&w.TputDist), Util:jsonDistribution(&w.UtilDist)}
+				for c := range WorkerColumns {
+					jw.Metrics.set(WorkerColumns[c].Name, WorkerColumns[c].Value(w))
+				}
+				if showWait {
+					d := jsonDistribution(&w.RunnableDist)
+					jw.Rwait = &d
+				}
+				workers = append(workers, jw)
+			}
+			js.Workers = &workers
+		}
+		jr.Sets = append(jr.Sets, js)
+	}
+	return
+}
+
+// Write the report to the named file, or stdout if it's empty
+func (plan *BenchmarkPlan) JSONReport(output string) (err error) {
+	err = plan.Process()
+	if err != nil {
+		return
+	}
+
+	rpt := JSONReport{
+		Schema:JSONReportSchema,
+		Plan:plan.filename,
+		ExcludeAnomalies:ExcludeAnomalies,
+		Hosts:plan.Hosts(),
+		Runs:[]JSONRun{},
+		Schedulers:[]JSONScheduler{},
+	}
+	if rpt.Hosts == nil {
+		rpt.Hosts = []string{}
+	}
+
+	if plan.Calibration != nil {
+		rpt.Calibration = &JSONCalibration{NsPerKop:plan.Calibration.NsPerKop,
+			Presets:make(map[string]JSONPreset)}
+		for name, cal := range plan.Calibration.Presets {
+			rpt.Calibration.Presets[name] = JSONPreset{Tput:cal.Tput,
+				NsPerKop:cal.NsPerKop, DutyCycle:cal.DutyCycle}
+		}
+	}
+
+	for i := range plan.Runs {
+		rpt.Runs = append(rpt.Runs, jsonRun(i, &plan.Runs[i]))
+	}
+
+	for _, sc := range plan.SchedulerScores() {
+		rpt.Schedulers = append(rpt.Schedulers, JSONScheduler{Scheduler:sc.Scheduler,
+			Sets:sc.Sets, Score:jsonFloat(sc.Score), Worst:jsonFloat(sc.Worst)})
+	}
+
+	var b []byte
+	b, err = json.MarshalIndent(rpt, "", "  ")
+	if err != nil {
+		return
+	}
+	b = append(b, '\n')
+
+	if output == "" {
+		_, err = os.Stdout.Write(b)
+		return
+	}
+	err = ioutil.WriteFile(output, b, 0666)
+	return
+}
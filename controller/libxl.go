@@ -166,6 +166,131 @@ func (c C.libxl_cpupoolinfo) toGo() (g CpupoolInfo) {
 	return
 }
 
+// libxl_vcpuinfo = Struct("vcpuinfo", [
+//     ("vcpuid", uint32),
+//     ("cpu", uint32),
+//     ("online", bool),
+//     ("blocked", bool),
+//     ("running", bool),
+//     ("vcpu_time", uint64), # total vcpu time ran (ns)
+//     ("cpumap", libxl_bitmap), # current cpu's affinities
+//     ("cpumap_soft", libxl_bitmap), # current soft cpu affinity
+//     ], dir=DIR_OUT)
+
+type Vcpuinfo struct {
+	Vcpuid     uint32
+	Cpu        uint32
+	Online     bool
+	Blocked    bool
+	Running    bool
+	VcpuTime   time.Duration
+	Cpumap     Bitmap
+	CpumapSoft Bitmap
+}
+
+func (c C.libxl_vcpuinfo) toGo() (g Vcpuinfo) {
+	g.Vcpuid = uint32(c.vcpuid)
+	g.Cpu = uint32(c.cpu)
+	g.Online = bool(c.online)
+	g.Blocked = bool(c.blocked)
+	g.Running = bool(c.running)
+	g.VcpuTime = time.Duration(c.vcpu_time)
+	g.Cpumap = bitmapCToGo(c.cpumap)
+	g.CpumapSoft = bitmapCToGo(c.cpumap_soft)
+
+	return
+}
+
+// libxl_physinfo = Struct("physinfo", [
+//     ("threads_per_core", uint32),
+//     ("cores_per_socket", uint32),
+//     ("max_cpu_id", uint32),
+//     ("nr_cpus", uint32),
+//     ("cpu_khz", uint32),
+//     ("total_pages", uint64),
+//     ("free_pages", uint64),
+//     ("scrub_pages", uint64),
+//     ("outstanding_pages", uint64),
+//     ("sharing_freed_pages", uint64),
+//     ("sharing_used_frames", uint64),
+//     ("nr_nodes", uint32),
+//     ("hw_cap", libxl_hwcap),
+//     ("cap_hvm", bool),
+//     ("cap_hvm_directio", bool),
+//     ], dir=DIR_OUT)
+
+type Physinfo struct {
+	ThreadsPerCore uint32
+	CoresPerSocket uint32
+	MaxCpuId       uint32
+	NrCpus         uint32
+	CpuKhz         uint32
+	TotalPages     uint64
+	FreePages      uint64
+	NrNodes        uint32
+}
+
+func (c C.libxl_physinfo) toGo() (g Physinfo) {
+	g.ThreadsPerCore = uint32(c.threads_per_core)
+	g.CoresPerSocket = uint32(c.cores_per_socket)
+	g.MaxCpuId = uint32(c.max_cpu_id)
+	g.NrCpus = uint32(c.nr_cpus)
+	g.CpuKhz = uint32(c.cpu_khz)
+	g.TotalPages = uint64(c.total_pages)
+	g.FreePages = uint64(c.free_pages)
+	g.NrNodes = uint32(c.nr_nodes)
+
+	return
+}
+
+// libxl_version_info = Struct("version_info", [
+//     ("xen_version_major", integer),
+//     ("xen_version_minor", integer),
+//     ("xen_version_extra", string),
+//     ("compiler",          string),
+//     ("compile_by",        string),
+//     ("compile_domain",    string),
+//     ("compile_date",      string),
+//     ("capabilities",      string),
+//     ("changeset",         string),
+//     ("virt_start",        uint64),
+//     ("pagesize",          integer),
+//     ("commandline",       string),
+//     ], dir=DIR_OUT)
+
+type VersionInfo struct {
+	XenVersionMajor int
+	XenVersionMinor int
+	XenVersionExtra string
+	Compiler        string
+	CompileDate     string
+	Capabilities    string
+	Changeset       string
+	Commandline     string
+}
+
+// libxl_sched_credit_params = Struct("sched_credit_params", [
+//     ("tslice_ms", integer),
+//     ("ratelimit_us", integer),
+//     ], dispose_fn=None)
+
+type SchedCreditParams struct {
+	TsliceMs    int
+	RatelimitUs int
+}
+
+// libxl_cputopology = Struct("cputopology", [
+//     ("core", uint32),
+//     ("socket", uint32),
+//     ("node", uint32),
+//     ], dir=DIR_OUT)
+
+type CpuTopology struct {
+	Core   uint32
+	Socket uint32
+	Node   uint32
+}
+
 /*
  * Context
  */
@@ -226,6 +351,155 @@ func (Ctx *Context) DomainInfo(Id Domid) (di Dominfo, err error) {
 	return
 }
 
+// libxl_dominfo * libxl_list_domain(libxl_ctx*, int *nb_domain_out);
+// void libxl_dominfo_list_free(libxl_dominfo *list, int nb_domain);
+func (Ctx *Context) ListDomain() (glist []Dominfo) {
+	err := Ctx.CheckOpen()
+	if err != nil {
+		return
+	}
+
+	var nbDomain C.int
+	clist := C.libxl_list_domain(Ctx.ctx, &nbDomain)
+	defer C.libxl_dominfo_list_free(clist, nbDomain)
+
+	if int(nbDomain) == 0 {
+		return
+	}
+
+	gslice := (*[1 << 30]C.libxl_dominfo)(unsafe.Pointer(clist))[:nbDomain:nbDomain]
+	for i := range gslice {
+		info := gslice[i].toGo()
+		glist = append(glist, info)
+	}
+
+	return
+}
+
+// libxl_vcpuinfo *libxl_list_vcpu(libxl_ctx *ctx, uint32_t domid,
+//                                 int *nb_vcpu, int *nr_cpus_out);
+// void libxl_vcpuinfo_list_free(libxl_vcpuinfo *, int nr_vcpus);
+func (Ctx *Context) ListVcpu(id Domid) (glist []Vcpuinfo) {
+	err := Ctx.CheckOpen()
+	if err != nil {
+		return
+	}
+
+	var nbVcpu C.int
+	var nrCpus C.int
+
+	clist := C.libxl_list_vcpu(Ctx.ctx, C.uint32_t(id), &nbVcpu, &nrCpus)
+	defer C.libxl_vcpuinfo_list_free(clist, nbVcpu)
+
+	if int(nbVcpu) == 0 {
+		return
+	}
+
+	gslice := (*[1 << 30]C.libxl_vcpuinfo)(unsafe.Pointer(clist))[:nbVcpu:nbVcpu]
+	for i := range gslice {
+		info := gslice[i].toGo()
+		glist = append(glist, info)
+	}
+
+	return
+}
+
+// int libxl_get_physinfo(libxl_ctx *ctx, libxl_physinfo *physinfo);
+func (Ctx *Context) GetPhysinfo() (physinfo Physinfo, err error) {
+	err = Ctx.CheckOpen()
+	if err != nil {
+		return
+	}
+
+	var cphys C.libxl_physinfo
+	C.libxl_physinfo_init(&cphys)
+	defer C.libxl_physinfo_dispose(&cphys)
+
+	ret := C.libxl_get_physinfo(Ctx.ctx, &cphys)
+	if ret != 0 {
+		err = fmt.Errorf("libxl_get_physinfo failed: %d", ret)
+		return
+	}
+
+	physinfo = cphys.toGo()
+
+	return
+}
+
+// libxl_cputopology *libxl_get_cpu_topology(libxl_ctx *ctx, int *nb_cpu_out);
+// void libxl_cputopology_list_free(libxl_cputopology *, int nb_cpu);
+func (Ctx *Context) GetCpuTopology() (glist []CpuTopology) {
+	err := Ctx.CheckOpen()
+	if err != nil {
+		return
+	}
+
+	var nbCpu C.int
+	clist := C.libxl_get_cpu_topology(Ctx.ctx, &nbCpu)
+	defer C.libxl_cputopology_list_free(clist, nbCpu)
+
+	if int(nbCpu) == 0 {
+		return
+	}
+
+	gslice := (*[1 << 30]C.libxl_cputopology)(unsafe.Pointer(clist))[:nbCpu:nbCpu]
+	for i := range gslice {
+		glist = append(glist, CpuTopology{
+			Core:uint32(gslice[i].core),
+			Socket:uint32(gslice[i].socket),
+			Node:uint32(gslice[i].node)})
+	}
+
+	return
+}
+
+// const libxl_version_info* libxl_get_version_info(libxl_ctx *ctx);
+func (Ctx *Context) GetVersionInfo() (info VersionInfo, err error) {
+	err = Ctx.CheckOpen()
+	if err != nil {
+		return
+	}
+
+	cinfo := C.libxl_get_version_info(Ctx.ctx)
+	if cinfo == nil {
+		err = fmt.Errorf("libxl_get_version_info failed")
+		return
+	}
+
+	info.XenVersionMajor = int(cinfo.xen_version_major)
+	info.XenVersionMinor = int(cinfo.xen_version_minor)
+	info.XenVersionExtra = C.GoString(cinfo.xen_version_extra)
+	info.Compiler = C.GoString(cinfo.compiler)
+	info.CompileDate = C.GoString(cinfo.compile_date)
+	info.Capabilities = C.GoString(cinfo.capabilities)
+	info.Changeset = C.GoString(cinfo.changeset)
+	info.Commandline = C.GoString(cinfo.commandline)
+
+	return
+}
+
+// int libxl_sched_credit_params_get(libxl_ctx *ctx, uint32_t poolid,
+//                                   libxl_sched_credit_params *scinfo);
+func (Ctx *Context) SchedCreditParamsGet(Poolid uint32) (params SchedCreditParams, err error) {
+	err = Ctx.CheckOpen()
+	if err != nil {
+		return
+	}
+
+	var cparams C.libxl_sched_credit_params
+
+	ret := C.libxl_sched_credit_params_get(Ctx.ctx, C.uint32_t(Poolid), &cparams)
+	if ret != 0 {
+		err = fmt.Errorf("libxl_sched_credit_params_get failed: %d", ret)
+		return
+	}
+
+	params.TsliceMs = int(cparams.tslice_ms)
+	params.RatelimitUs = int(cparams.ratelimit_us)
+
+	return
+}
+
 func (Ctx *Context) DomainUnpause(Id Domid) (err error) {
 	err = Ctx.CheckOpen()
 	if err != nil {
@@ -25,13 +25,22 @@ import (
 	"strconv"
 )
 
+// Set at build time with -X main.Version=...
+var Version = "unknown"
+
 func main() {
 	Args := os.Args
 
 	Args = Args[1:]
 	filename := "test.bench"
+	// All the files given with -f, for diff
+	var files []string
 	template := ""
 	verbosity := 0
+	axis := "scheduler"
+	tol := NewTolerances()
+	format := ""
+	output := ""
 
 	for len(Args) > 0 {
 		switch(Args[0]) {
@@ -41,6 +50,7 @@ func main() {
 				os.Exit(1)
 			}
 			filename = Args[1]
+			files = append(files, filename)
 			Args = Args[2:]
 		case "-t":
 			if len(Args) < 2 {
@@ -56,6 +66,40 @@ func main() {
 			}
 			verbosity, _ = strconv.Atoi(Args[1])
 			Args = Args[2:]
+		case "-a":
+			if len(Args) < 2 {
+				fmt.Println("Need arg for -a")
+				os.Exit(1)
+			}
+			axis = Args[1]
+			Args = Args[2:]
+		case "-format":
+			if len(Args) < 2 {
+				fmt.Println("Need arg for -format")
+				os.Exit(1)
+			}
+			format = Args[1]
+			Args = Args[2:]
+		case "-o":
+			if len(Args) < 2 {
+				fmt.Println("Need arg for -o")
+				os.Exit(1)
+			}
+			output = Args[1]
+			Args = Args[2:]
+		case "-x":
+			ExcludeAnomalies = true
+			Args = Args[1:]
+		case "-tol":
+			if len(Args) < 2 {
+				fmt.Println("Need arg for -tol")
+				os.Exit(1)
+			}
+			if err := tol.Set(Args[1]); err != nil {
+				fmt.Printf("Bad tolerance %s: %v\n", Args[1], err)
+				os.Exit(1)
+			}
+			Args = Args[2:]
 		case "plan":
 			// Load either the template benchmark or the filename
 			loadfile := filename
@@ -106,6 +150,20 @@ func main() {
 			}
 			Args = Args[1:]
 			
+		case "calibrate":
+			plan, err := LoadBenchmark(filename)
+			if err != nil {
+				fmt.Println("Loading benchmark ", filename, " ", err)
+				os.Exit(1)
+			}
+
+			err = plan.RunCalibration()
+			if err != nil {
+				fmt.Println("Calibrating:", err)
+				os.Exit(1)
+			}
+			Args = Args[1:]
+			
 		case "report":
 			Args = Args[1:]
 			plan, err := LoadBenchmark(filename)
@@ -114,11 +172,122 @@ func main() {
 				os.Exit(1)
 			}
 			
-			err = plan.TextReport(verbosity)
+			switch format {
+			case "", "text":
+				err = plan.TextReport(verbosity)
+			case "markdown":
+				err = plan.MarkdownReport(output, verbosity)
+			case "json":
+				err = plan.JSONReport(output)
+			default:
+				err = fmt.Errorf("Unknown report format %s", format)
+			}
 			if err != nil {
 				fmt.Println("Running benchmark run:", err)
 				os.Exit(1)
 			}
+		case "groupreport":
+			Args = Args[1:]
+			plan, err := LoadBenchmark(filename)
+			if err != nil {
+				fmt.Println("Loading benchmark ", filename, " ", err)
+				os.Exit(1)
+			}
+
+			err = plan.GroupReport(verbosity)
+			if err != nil {
+				fmt.Println("Reporting:", err)
+				os.Exit(1)
+			}
+		case "compare":
+			Args = Args[1:]
+			plan, err := LoadBenchmark(filename)
+			if err != nil {
+				fmt.Println("Loading benchmark ", filename, " ", err)
+				os.Exit(1)
+			}
+
+			err = plan.CompareReport(axis, verbosity)
+			if err != nil {
+				fmt.Println("Comparing:", err)
+				os.Exit(1)
+			}
+		case "overhead":
+			Args = Args[1:]
+			plan, err := LoadBenchmark(filename)
+			if err != nil {
+				fmt.Println("Loading benchmark ", filename, " ", err)
+				os.Exit(1)
+			}
+
+			err = plan.OverheadReport(verbosity)
+			if err != nil {
+				fmt.Println("Reporting:", err)
+				os.Exit(1)
+			}
+		case "scaling":
+			Args = Args[1:]
+			plan, err := LoadBenchmark(filename)
+			if err != nil {
+				fmt.Println("Loading benchmark ", filename, " ", err)
+				os.Exit(1)
+			}
+
+			err = plan.ScalingReport(verbosity)
+			if err != nil {
+				fmt.Println("Reporting:", err)
+				os.Exit(1)
+			}
+		case "export":
+			Args = Args[1:]
+			plan, err := LoadBenchmark(filename)
+			if err != nil {
+				fmt.Println("Loading benchmark ", filename, " ", err)
+				os.Exit(1)
+			}
+
+			err = plan.Export(output, format)
+			if err != nil {
+				fmt.Println("Exporting:", err)
+				os.Exit(1)
+			}
+		case "gnuplot":
+			Args = Args[1:]
+			plan, err := LoadBenchmark(filename)
+			if err != nil {
+				fmt.Println("Loading benchmark ", filename, " ", err)
+				os.Exit(1)
+			}
+
+			err = plan.Gnuplot(output, format)
+			if err != nil {
+				fmt.Println("Writing gnuplot files:", err)
+				os.Exit(1)
+			}
+		case "diff":
+			Args = Args[1:]
+			if len(files) != 2 {
+				fmt.Println("diff needs two files: -f old -f new")
+				os.Exit(1)
+			}
+			var plans [2]BenchmarkPlan
+			for i := range plans {
+				var err error
+				plans[i], err = LoadBenchmark(files[i])
+				if err != nil {
+					fmt.Println("Loading benchmark ", files[i], " ", err)
+					os.Exit(1)
+				}
+			}
+
+			regressions, err := DiffReport(&plans[0], &plans[1], tol, verbosity)
+			if err != nil {
+				fmt.Println("Comparing:", err)
+				os.Exit(1)
+			}
+			if regressions > 0 {
+				os.Exit(2)
+			}
 		case "htmlreport":
 			plan, err := LoadBenchmark(filename)
 			if err != nil {
@@ -126,7 +295,7 @@ func main() {
 				os.Exit(1)
 			}
 			
-			err = plan.HTMLReport()
+			err = plan.HTMLReport(output)
 			if err != nil {
 				fmt.Println("Running benchmark run:", err)
 				os.Exit(1)
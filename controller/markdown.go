@@ -0,0 +1,458 @@
+/*
+ * Copyright (C) 2016 George W. Dunlap, Citrix Systems UK Ltd
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation; version 2 of the
+ * License only.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301, USA.
+ */
+package main
+
+/*
+ * The text report as GitHub-flavored Markdown, for pasting into
+ * issues and mailing list posts.  Levels are the same as for
+ * TextReport.
+ */
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type MarkdownTable struct {
+	Header []string
+	Rows [][]string
+}
+
+func mdEscape(s string) string {
+	s = strings.Replace(s, "\\", "\\\\", -1)
+	s = strings.Replace(s, "|", "\\|", -1)
+	return strings.Replace(s, "\n", " ", -1)
+}
+
+func (t *MarkdownTable) AddRow(cells ...string) {
+	t.Rows = append(t.Rows, cells)
+}
+
+// Columns which are all numbers are right-aligned
+func (t *MarkdownTable) Output(w io.Writer) {
+	numeric := make([]bool, len(t.Header))
+	for c := range numeric {
+		numeric[c] = len(t.Rows) > 0
+		for _, row := range t.Rows {
+			if c < len(row) && row[c] != "" && row[c] != "-" {
+				if _, err := strconv.ParseFloat(row[c], 64); err != nil {
+					numeric[c] = false
+				}
+			}
+		}
+	}
+
+	fmt.Fprintf(w, "\n|")
+	for _, h := range t.Header {
+		fmt.Fprintf(w, " %s |", mdEscape(h))
+	}
+	fmt.Fprintf(w, "\n|")
+	for c := range t.Header {
+		if numeric[c] {
+			fmt.Fprintf(w, " ---: |")
+		} else {
+			fmt.Fprintf(w, " --- |")
+		}
+	}
+	fmt.Fprintf(w, "\n")
+	for _, row := range t.Rows {
+		fmt.Fprintf(w, "|")
+		for c := range t.Header {
+			cell := ""
+			if c < len(row) {
+				cell = row[c]
+			}
+			fmt.Fprintf(w, " %s |", mdEscape(cell))
+		}
+		fmt.Fprintf(w, "\n")
+	}
+}
+
+func mdFloat(v float64) string {
+	return fmt.Sprintf("%.2f", v)
+}
+
+func mdDistRow(t *MarkdownTable, id string, name string, d *Distribution) {
+	t.AddRow(id, name, mdFloat(d.P1), mdFloat(d.P5), mdFloat(d.P50),
+		mdFloat(d.P95), mdFloat(d.P99), mdFloat(d.IQR), mdFloat(d.CoV))
+}
+
+var mdDistHeader = []string{"window", "p1", "p5", "p50", "p95", "p99", "iqr", "cov"}
+
+func (run *BenchmarkRun) MarkdownReport(w io.Writer, i int, level int) (err error) {
+	var done bool
+	done, err = run.checkSummary()
+	if err != nil {
+		return
+	}
+	if ! done {
+		err = fmt.Errorf("Run not yet processed")
+		return
+	}
+
+	fmt.Fprintf(w, "\n## Run %d: %s\n\n", i, mdEscape(run.Label))
+
+	for a := range RunAxes {
+		if v := RunAxes[a].Value(run); v != "" {
+			fmt.Fprintf(w, "- **%s**: `%s`\n", RunAxes[a].Name, v)
+		}
+	}
+	for set := range run.WorkerSets {
+		fmt.Fprintf(w, "- **set %d**: `%s`\n", set, strings.Join(run.WorkerSets[set].Params.Args, " "))
+	}
+	if run.Repeat > 0 {
+		fmt.Fprintf(w, "- **repeat**: %d\n", run.Repeat)
+	}
+	if run.Baseline {
+		fmt.Fprintf(w, "- **baseline**\n")
+	}
+	if run.Results.Host != nil {
+		fmt.Fprintf(w, "- **host**: %s\n", mdEscape(run.Results.Host.Identity()))
+	}
+	if run.Results.KHZ != 0 {
+		fmt.Fprintf(w, "- **cpu kHZ**: %d (%s)\n", run.Results.KHZ, run.Results.KHZMethod)
+	}
+	if run.Results.StopReason != "" {
+		fmt.Fprintf(w, "- **stopped**: %s\n", run.Results.StopReason)
+	}
+
+	sets := run.Results.Summary
+	rwait, rwaitavg := run.rwaitNames()
+	summary := MarkdownTable{Header: []string{"set"}}
+	metrics := UsedSetMetrics(SummarySetMetrics, sets)
+	for _, m := range metrics {
+		name := SetMetrics[m].Name
+		if name == "rwaitavg" {
+			name = rwaitavg
+		}
+		summary.Header = append(summary.Header, name)
+	}
+	for set := range sets {
+		row := []string{fmt.Sprintf("%d", set)}
+		for _, m := range metrics {
+			row = append(row, mdFloat(SetMetrics[m].Value(&sets[set])))
+		}
+		summary.AddRow(row...)
+	}
+	summary.Output(w)
+	if rwaitavg != "rwaitavg" {
+		fmt.Fprintf(w, "\n%s\n", RunstateEstimateNote)
+	}
+
+	pairs := MarkdownTable{Header: []string{"pair", "rtrips/s", "rttavg", "rttmin", "rttmax"}}
+	for set := range run.WorkerSets {
+		if run.WorkerSets[set].PairWith == nil {
+			continue
+		}
+		peer := *run.WorkerSets[set].PairWith
+		for id := range sets[set].Workers {
+			s := &sets[set].Workers[id]
+			pairs.AddRow(fmt.Sprintf("%d:%d-%d:%d", set, id, peer, id),
+				mdFloat(s.MsgRate), mdFloat(s.AvgRtt / USEC),
+				mdFloat(s.MinMaxRtt.Min / USEC), mdFloat(s.MinMaxRtt.Max / USEC))
+		}
+	}
+	if len(pairs.Rows) > 0 {
+		pairs.Output(w)
+	}
+
+	if run.Results.JainIndex > 0 {
+		fair := MarkdownTable{Header: []string{"set", "fshare", "uavgavg", "fdevavg", "fdevmax", "jain", "tdegr", "texpdegr", "tratio"}}
+		for set := range sets {
+			ws := &sets[set]
+			fair.AddRow(fmt.Sprintf("%d", set), mdFloat(ws.FairShare), mdFloat(ws.AvgAvgUtil),
+				mdFloat(ws.AvgFairDev), mdFloat(ws.MaxFairDev), mdFloat(ws.JainIndex),
+				mdFloat(ws.Degradation), mdFloat(ws.ExpectedDegradation), mdFloat(ws.TputRatio))
+		}
+		fair.Output(w)
+		fmt.Fprintf(w, "\nJain's fairness index (all workers): %.3f\n", run.Results.JainIndex)
+	}
+
+	if pool := run.Results.Pool; pool != nil {
+		fmt.Fprintf(w, "\nPool cpus %v: %.1f%% idle", run.Results.PoolCpus, pool.IdleFrac * 100)
+		if pool.Intervals > 0 {
+			fmt.Fprintf(w, "; idle while workers were runnable in %d of %d intervals (%.2f cpu-s)",
+				pool.Violations, pool.Intervals, pool.WastedCpu)
+			if run.runstateEstimated() {
+				fmt.Fprintf(w, " ~")
+			}
+		}
+		fmt.Fprintf(w, "\n")
+		if level >= 1 {
+			t := MarkdownTable{Header: []string{"cpu", "idle"}}
+			for c, cpu := range run.Results.PoolCpus {
+				t.AddRow(fmt.Sprintf("%d", cpu), mdFloat(pool.CpuIdleFrac[c]))
+			}
+			t.Output(w)
+		}
+	}
+
+	if len(run.Results.Anomalies) > 0 {
+		t := MarkdownTable{Header: []string{"worker", "kind", "excluded", "detail"}}
+		for a := range run.Results.Anomalies {
+			an := &run.Results.Anomalies[a]
+			// Outliers can be numerous; list them with -v 1
+			if an.Kind == AnomalyOutlier && level < 1 {
+				continue
+			}
+			t.AddRow(an.Id.String(), an.Kind, fmt.Sprintf("%v", an.Excluded), an.String())
+		}
+		fmt.Fprintf(w, "\n%d anomalies", len(run.Results.Anomalies))
+		if len(t.Rows) < len(run.Results.Anomalies) {
+			fmt.Fprintf(w, " (%d outliers listed with -v 1)", len(run.Results.Anomalies) - len(t.Rows))
+		}
+		fmt.Fprintf(w, "\n")
+		if len(t.Rows) > 0 {
+			t.Output(w)
+		}
+	}
+
+	warned := false
+	for p := range run.Results.Protocol {
+		if problems := run.Results.Protocol[p].Problems(); problems != "" {
+			if ! warned {
+				fmt.Fprintf(w, "\n")
+				warned = true
+			}
+			fmt.Fprintf(w, "> **WARNING**: Worker %v protocol: %s\n", run.Results.Protocol[p].Id, problems)
+		}
+	}
+
+	showWait := run.hasRunstate()
+
+	if level >= 1 {
+		fmt.Fprintf(w, "\n### Distributions\n")
+		t := MarkdownTable{Header: append([]string{"set"}, mdDistHeader...)}
+		for set := range sets {
+			ws := &sets[set]
+			id := fmt.Sprintf("%d", set)
+			mdDistRow(&t, id, "tput", &ws.TputDist)
+			mdDistRow(&t, id, "util", &ws.UtilDist)
+			if showWait {
+				mdDistRow(&t, id, rwait, &ws.RunnableDist)
+			}
+		}
+		t.Output(w)
+
+		if len(run.Results.System) > 0 {
+			var tputs, utils []float64
+			starved, maxStarved := 0, 0
+			for _, sw := range run.Results.System {
+				tputs = append(tputs, sw.Tput)
+				utils = append(utils, sw.Util)
+				if sw.Starved > 0 {
+					starved++
+				}
+				if sw.Starved > maxStarved {
+					maxStarved = sw.Starved
+				}
+			}
+			fmt.Fprintf(w, "\nSystem: %d windows of %v, %d with starved workers (at most %d)\n",
+				len(run.Results.System), time.Duration(SystemWindowSize), starved, maxStarved)
+			t := MarkdownTable{Header: append([]string{""}, mdDistHeader...)}
+			d := NewDistribution(tputs)
+			mdDistRow(&t, "system", "tput", &d)
+			d = NewDistribution(utils)
+			mdDistRow(&t, "system", "util", &d)
+			t.Output(w)
+
+			if level >= 2 {
+				t := MarkdownTable{Header: []string{"start", "tput", "util", "starved"}}
+				for _, sw := range run.Results.System {
+					t.AddRow(fmt.Sprintf("%.1f", float64(sw.Start) / SEC), mdFloat(sw.Tput),
+						mdFloat(sw.Util), fmt.Sprintf("%d", sw.Starved))
+				}
+				t.Output(w)
+			}
+		}
+	}
+
+	if level >= 2 {
+		t := MarkdownTable{Header: append([]string{"workerid"}, mdDistHeader...)}
+		for set := range sets {
+			for id := range sets[set].Workers {
+				s := &sets[set].Workers[id]
+				wid := fmt.Sprintf("%d:%d", set, id)
+				mdDistRow(&t, wid, "tput", &s.TputDist)
+				mdDistRow(&t, wid, "util", &s.UtilDist)
+				if showWait {
+					mdDistRow(&t, wid, rwait, &s.RunnableDist)
+				}
+			}
+		}
+		t.Output(w)
+	}
+
+	if level >= 1 {
+		fmt.Fprintf(w, "\n### Workers\n")
+		showFair := run.Results.JainIndex > 0
+		t := MarkdownTable{Header: []string{"workerid", "toput", "time", "cpu", "tavg", "tmin", "tmax", "uavg", "umin", "umax"}}
+		if showFair {
+			t.Header = append(t.Header, "fshare", "fdev", "tratio")
+		}
+		if showWait {
+			t.Header = append(t.Header, rwait)
+		}
+		for set := range sets {
+			for id := range sets[set].Workers {
+				s := &sets[set].Workers[id]
+				row := []string{fmt.Sprintf("%d:%d", set, id), fmt.Sprintf("%d", s.TotalTput),
+					mdFloat(s.TotalTime.Seconds()), mdFloat(s.TotalCputime.Seconds()),
+					mdFloat(s.AvgTput), mdFloat(s.MinMaxTput.Min), mdFloat(s.MinMaxTput.Max),
+					mdFloat(s.AvgUtil), mdFloat(s.MinMaxUtil.Min), mdFloat(s.MinMaxUtil.Max)}
+				if showFair {
+					row = append(row, mdFloat(s.FairShare), mdFloat(s.FairDev), mdFloat(s.TputRatio))
+				}
+				if showWait {
+					row = append(row, mdFloat(s.RunnableFrac))
+				}
+				t.AddRow(row...)
+			}
+		}
+		t.Output(w)
+	}
+
+	// The raw reports are too long for a table; put them in a
+	// collapsed code block
+	if level >= 2 {
+		for set := range sets {
+			for id := range sets[set].Workers {
+				s := &sets[set].Workers[id]
+				fmt.Fprintf(w, "\n<details><summary>Worker %d:%d reports</summary>\n\n```\n", set, id)
+				var le WorkerReport
+				for _, e := range s.Raw {
+					var dtime float64
+					var dCputime time.Duration
+					var dKops int
+					if e.Now > le.Now {
+						dtime = float64(e.Now - le.Now) / SEC
+						dCputime = e.Cputime - le.Cputime
+						dKops = e.Kops - le.Kops
+					}
+					fmt.Fprintf(w, "[%8.3f] (%8.3f) %8.3f (%8.3f) %8d (%8d) %12d\n",
+						float64(e.Now) / SEC, dtime,
+						e.Cputime.Seconds(), dCputime.Seconds(),
+						e.Kops, dKops, e.MaxDelta)
+					le = e
+				}
+				fmt.Fprintf(w, "```\n\n</details>\n")
+			}
+		}
+	}
+
+	return
+}
+
+func (plan *BenchmarkPlan) outputMarkdown(w io.Writer, level int) (err error) {
+	title := plan.filename
+	if title == "" {
+		title = "schedbench"
+	}
+	fmt.Fprintf(w, "# %s\n\n", mdEscape(title))
+
+	completed := 0
+	for i := range plan.Runs {
+		if plan.Runs[i].Completed {
+			completed++
+		}
+	}
+	fmt.Fprintf(w, "- **runs**: %d of %d completed\n", completed, len(plan.Runs))
+	if ExcludeAnomalies {
+		fmt.Fprintf(w, "- **anomalous windows excluded**\n")
+	}
+
+	if plan.Calibration != nil {
+		fmt.Fprintf(w, "\n## Calibration\n\nBurn rate: %.2f ns/kop\n", plan.Calibration.NsPerKop)
+		var names []string
+		for name := range plan.Calibration.Presets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		t := MarkdownTable{Header: []string{"preset", "tput", "ns/kop", "duty"}}
+		for _, name := range names {
+			cal := plan.Calibration.Presets[name]
+			t.AddRow(name, mdFloat(cal.Tput), mdFloat(cal.NsPerKop), mdFloat(cal.DutyCycle))
+		}
+		t.Output(w)
+	}
+
+	hosts := plan.Hosts()
+	if len(hosts) > 0 {
+		fmt.Fprintf(w, "\n## Hosts\n\n")
+		for _, h := range hosts {
+			fmt.Fprintf(w, "- %s\n", mdEscape(h))
+		}
+		if len(hosts) > 1 {
+			fmt.Fprintf(w, "\n> **WARNING**: Runs in this plan were done on %d different hosts or builds\n", len(hosts))
+		}
+	}
+
+	for i := range plan.Runs {
+		r := &plan.Runs[i]
+		if ! r.Completed {
+			// stdout may be the report
+			fmt.Fprintf(os.Stderr, "Test [%d] %s not run\n", i, r.Label)
+		}
+
+		err = r.MarkdownReport(w, i, level)
+		if err != nil {
+			return
+		}
+	}
+
+	scores := plan.SchedulerScores()
+	if len(scores) > 0 {
+		fmt.Fprintf(w, "\n## Subjective fairness\n")
+		t := MarkdownTable{Header: []string{"scheduler", "sets", "score", "worst"}}
+		for _, sc := range scores {
+			t.AddRow(sc.Scheduler, fmt.Sprintf("%d", sc.Sets), mdFloat(sc.Score), mdFloat(sc.Worst))
+		}
+		t.Output(w)
+	}
+	return
+}
+
+// Write the report to the named file, or stdout if it's empty
+func (plan *BenchmarkPlan) MarkdownReport(output string, level int) (err error) {
+	err = plan.Process()
+	if err != nil {
+		return
+	}
+
+	if output == "" {
+		err = plan.outputMarkdown(os.Stdout, level)
+		return
+	}
+
+	var f *os.File
+	f, err = os.Create(output)
+	if err != nil {
+		return
+	}
+	err = plan.outputMarkdown(f, level)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return
+}
@@ -0,0 +1,207 @@
+/*
+ * Copyright (C) 2016 George W. Dunlap, Citrix Systems UK Ltd
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation; version 2 of the
+ * License only.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301, USA.
+ */
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Where the pool's cpu time went during a run, in cpu-seconds
+type OverheadSummary struct {
+	Capacity float64
+	// Only known if the pcpus were sampled
+	Idle float64
+	IdleKnown bool
+	// Cpu time charged to the workers
+	Accounted float64
+	// Neither idle nor charged to a worker: the hypervisor,
+	// including the scheduler (or, if the idle time isn't known,
+	// idle time as well)
+	Unaccounted float64
+	OverheadFrac float64
+	// Cpu time the workers' throughput would take at the
+	// uncontended burn rate, and that as a fraction of Accounted
+	Useful float64
+	Efficiency float64
+}
+
+func (run *BenchmarkRun) WorkerCount() (count int) {
+	for set := range run.WorkerSets {
+		count += run.WorkerSets[set].Count
+	}
+	return
+}
+
+// The cpus the run's workers could run on
+func (run *BenchmarkRun) poolCpuCount(plan *BenchmarkPlan) int {
+	if n := len(run.Results.PoolCpus); n > 0 {
+		return n
+	}
+	rc := run.RunConfig
+	rc.PropagateFrom(plan.RunConfig)
+	if n := len(rc.Cpus); n > 0 {
+		return n
+	}
+	if h := run.Results.Host; h != nil {
+		return len(h.PoolCpus)
+	}
+	return 0
+}
+
+// Compare what the workers were charged for, and what they got done,
+// with the capacity of the pool.
+func (plan *BenchmarkPlan) processOverhead(run *BenchmarkRun) {
+	run.Results.Overhead = nil
+	if !run.Completed {
+		return
+	}
+
+	cpus := run.poolCpuCount(plan)
+	if cpus == 0 {
+		return
+	}
+
+	var o OverheadSummary
+	var span float64
+	ps := run.Results.PcpuSamples
+	if pool := run.Results.Pool; pool != nil && len(ps) >= 2 {
+		// Measure everything over the span of the pcpu samples
+		start, end := ps[0].Now, ps[len(ps)-1].Now
+		span = float64(end - start) / SEC
+		o.Capacity = float64(cpus) * span
+		o.Idle = pool.IdleFrac * o.Capacity
+		o.IdleKnown = true
+
+		t, cpu := run.cpuSeries()
+		if len(t) == 0 {
+			return
+		}
+		for id := range t {
+			o.Accounted += (interpolate(t[id], cpu[id], end) - interpolate(t[id], cpu[id], start)) / SEC
+		}
+	} else {
+		// Without samples, all we have is each worker's totals
+		n := 0
+		for set := range run.Results.Summary {
+			for _, s := range run.Results.Summary[set].Workers {
+				span += s.TotalTime.Seconds()
+				o.Accounted += s.TotalCputime.Seconds()
+				n++
+			}
+		}
+		if n == 0 {
+			return
+		}
+		span /= float64(n)
+		o.Capacity = float64(cpus) * span
+	}
+	if o.Capacity <= 0 {
+		return
+	}
+
+	o.Unaccounted = o.Capacity - o.Idle - o.Accounted
+	o.OverheadFrac = o.Unaccounted / o.Capacity
+
+	if plan.Calibration != nil && plan.Calibration.NsPerKop > 0 && o.Accounted > 0 {
+		var tput float64
+		for set := range run.Results.Summary {
+			tput += run.Results.Summary[set].TotalTput
+		}
+		o.Useful = tput * plan.Calibration.NsPerKop / SEC * span
+		o.Efficiency = o.Useful / o.Accounted
+	}
+
+	run.Results.Overhead = &o
+}
+
+func (plan *BenchmarkPlan) OverheadReport(level int) (err error) {
+	err = plan.Process()
+	if err != nil {
+		return
+	}
+
+	fmt.Printf("== OVERHEAD ==\n")
+	fmt.Printf("Cpu-seconds of pool capacity, idle, charged to workers, and unaccounted for\n")
+	fmt.Printf("(hypervisor and scheduler; includes idle if idle is '-')\n\n")
+
+	fmt.Printf("%8s %8s %8s %8s %8s %8s %8s %8s  %s\n", "workers", "cap", "idle", "acct",
+		"unacct", "ovhd%", "useful", "eff%", "label")
+	// Per scheduler, the overhead of each run by worker count
+	scaling := make(map[string]map[int][]float64)
+	for i := range plan.Runs {
+		r := &plan.Runs[i]
+		o := r.Results.Overhead
+		if o == nil {
+			continue
+		}
+		idle := "-"
+		if o.IdleKnown {
+			idle = fmt.Sprintf("%.2f", o.Idle)
+		}
+		useful, eff := "-", "-"
+		if o.Useful > 0 {
+			useful = fmt.Sprintf("%.2f", o.Useful)
+			eff = fmt.Sprintf("%.1f", o.Efficiency * 100)
+		}
+		fmt.Printf("%8d %8.2f %8s %8.2f %8.2f %8.1f %8s %8s  %s\n", r.WorkerCount(),
+			o.Capacity, idle, o.Accounted, o.Unaccounted, o.OverheadFrac * 100,
+			useful, eff, r.Label)
+
+		if r.Baseline {
+			continue
+		}
+		rc := r.RunConfig
+		rc.PropagateFrom(plan.RunConfig)
+		sched := rc.Scheduler
+		if sched == "" {
+			sched = "default"
+		}
+		if scaling[sched] == nil {
+			scaling[sched] = make(map[int][]float64)
+		}
+		scaling[sched][r.WorkerCount()] = append(scaling[sched][r.WorkerCount()], o.OverheadFrac)
+	}
+
+	if len(scaling) == 0 {
+		return
+	}
+
+	var scheds []string
+	for sched := range scaling {
+		scheds = append(scheds, sched)
+	}
+	sort.Strings(scheds)
+
+	fmt.Printf("\nMean overhead%% by number of workers\n")
+	fmt.Printf("%10s %8s %8s %8s %8s\n", "scheduler", "workers", "runs", "ovhd%", "stddev")
+	for _, sched := range scheds {
+		var counts []int
+		for count := range scaling[sched] {
+			counts = append(counts, count)
+		}
+		sort.Ints(counts)
+		for _, count := range counts {
+			x := scaling[sched][count]
+			fmt.Printf("%10s %8d %8d %8.1f %8.1f\n", sched, count, len(x),
+				Mean(x) * 100, SampleStdDev(x) * 100)
+		}
+	}
+	return
+}
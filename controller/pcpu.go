@@ -0,0 +1,150 @@
+/*
+ * Copyright (C) 2016 George W. Dunlap, Citrix Systems UK Ltd
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation; version 2 of the
+ * License only.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301, USA.
+ */
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// /proc/stat counts in USER_HZ, which is 100 on every architecture
+// we care about
+const ProcStatTick = 10 * time.Millisecond
+
+// Idle (and iowait) time of each cpu in /proc/stat, indexed by cpu
+func procStatIdle() (idle map[int]time.Duration, err error) {
+	var b []byte
+	b, err = ioutil.ReadFile("/proc/stat")
+	if err != nil {
+		return
+	}
+
+	idle = make(map[int]time.Duration)
+	for _, line := range strings.Split(string(b), "\n") {
+		f := strings.Fields(line)
+		// cpuN user nice system idle iowait ...
+		if len(f) < 6 || !strings.HasPrefix(f[0], "cpu") || f[0] == "cpu" {
+			continue
+		}
+		var cpu int
+		cpu, err = strconv.Atoi(strings.TrimPrefix(f[0], "cpu"))
+		if err != nil {
+			return
+		}
+		var ticks int64
+		for _, s := range f[4:6] {
+			var t int64
+			t, err = strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return
+			}
+			ticks += t
+		}
+		idle[cpu] = time.Duration(ticks) * ProcStatTick
+	}
+	return
+}
+
+// Samples the idle time of the cpus the run's workers run on
+type PcpuSampler struct {
+	Cpus []int
+	workerType int
+	maxCpus int
+}
+
+func (run *BenchmarkRun) NewPcpuSampler(workerType int) (ps *PcpuSampler, err error) {
+	ps = &PcpuSampler{workerType:workerType}
+
+	switch workerType {
+	case WorkerXen:
+		var physinfo Physinfo
+		physinfo, err = Ctx.GetPhysinfo()
+		if err != nil {
+			return
+		}
+		ps.maxCpus = int(physinfo.MaxCpuId) + 1
+
+		err = Xch.Open()
+		if err != nil {
+			return
+		}
+
+		cpumap := run.GetCpumap()
+		for i := 0; i <= cpumap.Max(); i++ {
+			if cpumap.Test(i) {
+				ps.Cpus = append(ps.Cpus, i)
+			}
+		}
+	case WorkerProcess:
+		// Processes run wherever Linux puts them, unless Cpus
+		// says otherwise
+		if len(run.RunConfig.Cpus) > 0 {
+			ps.Cpus = append(ps.Cpus, run.RunConfig.Cpus...)
+		} else {
+			var idle map[int]time.Duration
+			idle, err = procStatIdle()
+			if err != nil {
+				return
+			}
+			for cpu := range idle {
+				ps.Cpus = append(ps.Cpus, cpu)
+			}
+			sort.Ints(ps.Cpus)
+		}
+	default:
+		err = fmt.Errorf("Unknown type: %d", workerType)
+	}
+	return
+}
+
+func (ps *PcpuSampler) Sample() (s PcpuSample, ok bool) {
+	s.Now = time.Now().UnixNano()
+
+	switch ps.workerType {
+	case WorkerXen:
+		idle, err := Xch.CpuIdle(ps.maxCpus)
+		if err != nil {
+			return
+		}
+		for _, cpu := range ps.Cpus {
+			if cpu >= len(idle) {
+				return
+			}
+			s.Idle = append(s.Idle, idle[cpu])
+		}
+	case WorkerProcess:
+		idle, err := procStatIdle()
+		if err != nil {
+			return
+		}
+		for _, cpu := range ps.Cpus {
+			t, present := idle[cpu]
+			if !present {
+				return
+			}
+			s.Idle = append(s.Idle, t)
+		}
+	}
+	ok = true
+	return
+}
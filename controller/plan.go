@@ -27,6 +27,11 @@ type PlanSimpleMatrix struct {
 	Workers []string
 	Count []int
 	NumaDisable []bool
+	// Pairs of presets (initiator -> responder) which should
+	// ping-pong with each other when both are in a run
+	Pairs map[string]string
+	// Run the whole matrix this many times
+	Repeat int
 }
 
 type PlanInput struct {
@@ -38,6 +43,21 @@ var WorkerPresets = map[string]WorkerParams{
 	"P001":WorkerParams{[]string{"burnwait", "70", "200000"}},
 }
 
+// A preset from the plan's input, or a built-in one, as ExpandInput
+// finds them
+func (plan *BenchmarkPlan) lookupPreset(name string) (wp WorkerParams, err error) {
+	wp, ok := WorkerPresets[name]
+	if plan.Input != nil {
+		if p, found := plan.Input.WorkerPresets[name]; found {
+			wp, ok = p, true
+		}
+	}
+	if !ok || wp.Args == nil {
+		err = fmt.Errorf("Invalid worker preset: %s", name)
+	}
+	return
+}
+
 func (plan *BenchmarkPlan) ClearRuns() (err error) {
 	plan.Runs = nil
 
@@ -73,6 +93,24 @@ func (plan *BenchmarkPlan) ExpandInput() (err error) {
 		schedulers = append(schedulers, "")
 	}
 
+	// Paired presets can't run without their partner, so both must
+	// be in the matrix
+	pairs := plan.Input.SimpleMatrix.Pairs
+	responder := make(map[string]bool)
+	for pi, pr := range pairs {
+		found := 0
+		for _, wn := range plan.Input.SimpleMatrix.Workers {
+			if wn == pi || wn == pr {
+				found++
+			}
+		}
+		if found != 2 || pi == pr || responder[pr] {
+			err = fmt.Errorf("Invalid pair %s -> %s", pi, pr)
+			return
+		}
+		responder[pr] = true
+	}
+
 	// Start by making a slice with baselines and each of the counts
 	var a, b []BenchmarkRun
 	
@@ -83,13 +121,26 @@ func (plan *BenchmarkPlan) ExpandInput() (err error) {
 			err = fmt.Errorf("Invalid worker preset: %s", wn)
 			return
 		}
+
+		// The baseline for a pair is the pair by itself
+		if responder[wn] {
+			continue
+		}
 		
 		run := BenchmarkRun{
-			WorkerSets:[]WorkerSet{{Params:wp, Count:1}},
+			WorkerSets:[]WorkerSet{{Params:wp, Count:1, Preset:wn}},
 			RuntimeSeconds:10,
+			Baseline:true,
 		}
 
 		run.Label = wn+" baseline"
+		if pr, ok := pairs[wn]; ok {
+			run.WorkerSets[0].PairWith = new(int)
+			*run.WorkerSets[0].PairWith = 1
+			run.WorkerSets = append(run.WorkerSets,
+				WorkerSet{Params:WorkerPresets[pr], Count:1, Preset:pr})
+			run.Label = wn+"+"+pr+" baseline"
+		}
 		a = append(a, run)
 	}
 
@@ -99,6 +150,7 @@ func (plan *BenchmarkPlan) ExpandInput() (err error) {
 			RuntimeSeconds:10,
 		}
 		
+		setIndex := make(map[string]int)
 		for _, wn := range plan.Input.SimpleMatrix.Workers {
 			wp := WorkerPresets[wn]
 			
@@ -107,10 +159,16 @@ func (plan *BenchmarkPlan) ExpandInput() (err error) {
 			}
 			run.Label = fmt.Sprintf("%s%s %d", run.Label, wn, c)
 
-			ws := WorkerSet{Params:wp, Count:c}
+			setIndex[wn] = len(run.WorkerSets)
+			ws := WorkerSet{Params:wp, Count:c, Preset:wn}
 			run.WorkerSets = append(run.WorkerSets, ws)
 		}
 
+		for pi, pr := range pairs {
+			run.WorkerSets[setIndex[pi]].PairWith = new(int)
+			*run.WorkerSets[setIndex[pi]].PairWith = setIndex[pr]
+		}
+
 		a = append(a, run)
 	}
 
@@ -119,6 +177,7 @@ func (plan *BenchmarkPlan) ExpandInput() (err error) {
 		for _, base := range a {
 			for _, s := range schedulers {
 				run := base
+				run.WorkerSets = append([]WorkerSet(nil), base.WorkerSets...)
 				run.RunConfig.Scheduler = s
 				run.Label = run.Label+" "+s
 				b = append(b, run)
@@ -133,6 +192,7 @@ func (plan *BenchmarkPlan) ExpandInput() (err error) {
 		for _, base := range a {
 			for _, d := range plan.Input.SimpleMatrix.NumaDisable {
 				run := base
+				run.WorkerSets = append([]WorkerSet(nil), base.WorkerSets...)
 				// Need to make a copy of this so that
 				// we have a pointer to use as a tristate
 				run.RunConfig.NumaDisable = new(bool)
@@ -149,9 +209,27 @@ func (plan *BenchmarkPlan) ExpandInput() (err error) {
 		b = nil
 	}
 
+	// ...and repeat the whole thing, so that anything which drifts
+	// over time is spread across all the configurations
+	if plan.Input.SimpleMatrix.Repeat > 1 {
+		for k := 0; k < plan.Input.SimpleMatrix.Repeat; k++ {
+			for _, base := range a {
+				run := base
+				run.WorkerSets = append([]WorkerSet(nil), base.WorkerSets...)
+				run.Repeat = k
+				run.Label = fmt.Sprintf("%s #%d", run.Label, k)
+				b = append(b, run)
+			}
+		}
+		a = b
+		b = nil
+	}
+
 	for i := range a {
 		fmt.Printf("%s\n", a[i].Label)
 	}
 	plan.Runs = a;
+
+	plan.LinkBaselines()
 	return
 }
@@ -20,23 +20,91 @@ package main
 
 import (
 	"fmt"
+	"os"
 	"os/exec"
-	"encoding/json"
 	"bufio"
 	"io"
-	
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 type ProcessWorker struct {
 	id WorkerId
 	c *exec.Cmd
 	stdout io.ReadCloser
-	jsonStarted bool
+	proto ProtocolParser
 	Log []string
+	// Set once the process has started; Sample runs in another
+	// goroutine, so only look at it with procLock held
+	procLock sync.Mutex
+	proc *os.Process
+	// Values at the first sample
+	firstSample time.Time
+	firstRunning time.Duration
+	firstWait time.Duration
 }
 
 func (w *ProcessWorker) SetId(i WorkerId) {
 	w.id = i
+	w.proto.Stats.Id = i
+}
+
+func (w *ProcessWorker) Protocol() WorkerProtocol {
+	return w.proto.Stats
+}
+
+// Set by Process once the worker has started, so take procLock
+func (w *ProcessWorker) process() *os.Process {
+	w.procLock.Lock()
+	defer w.procLock.Unlock()
+	return w.proc
+}
+
+// The first two fields of /proc/[pid]/schedstat are the time spent
+// on the cpu and the time spent waiting on a runqueue, in ns; any
+// other time the process was blocked.
+func (w *ProcessWorker) Sample(snap *CpuSnapshot) (s CpuSample, ok bool) {
+	proc := w.process()
+	if proc == nil {
+		return
+	}
+	now := time.Now()
+	b, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/schedstat", proc.Pid))
+	if err != nil {
+		return
+	}
+	f := strings.Fields(string(b))
+	if len(f) < 2 {
+		return
+	}
+	running, err := strconv.ParseInt(f[0], 10, 64)
+	if err != nil {
+		return
+	}
+	wait, err := strconv.ParseInt(f[1], 10, 64)
+	if err != nil {
+		return
+	}
+
+	if w.firstSample.IsZero() {
+		w.firstSample = now
+		w.firstRunning = time.Duration(running)
+		w.firstWait = time.Duration(wait)
+	}
+
+	s.Now = now.UnixNano()
+	s.Cputime = time.Duration(running)
+	s.Running = time.Duration(running) - w.firstRunning
+	s.Runnable = time.Duration(wait) - w.firstWait
+	s.Blocked = now.Sub(w.firstSample) - s.Running - s.Runnable
+	if s.Blocked < 0 {
+		s.Blocked = 0
+	}
+	ok = true
+	return
 }
 
 func (w *ProcessWorker) Init(p WorkerParams, g WorkerConfig) (err error) {
@@ -52,7 +120,38 @@ func (w *ProcessWorker) Init(p WorkerParams, g WorkerConfig) (err error) {
 }
 
 func (w *ProcessWorker) Shutdown() {
-	w.c.Process.Kill()
+	if proc := w.process(); proc != nil {
+		proc.Kill()
+	}
+}
+
+// Connect the two workers with a pair of pipes, passed to the worker
+// processes as fds 3 (read) and 4 (write).
+func (w *ProcessWorker) Pair(p Worker) (err error) {
+	peer, ok := p.(*ProcessWorker)
+	if !ok {
+		err = fmt.Errorf("Can't pair process worker with %T", p)
+		return
+	}
+
+	var pingr, pingw, pongr, pongw *os.File
+	pingr, pingw, err = os.Pipe()
+	if err != nil {
+		return
+	}
+	pongr, pongw, err = os.Pipe()
+	if err != nil {
+		pingr.Close()
+		pingw.Close()
+		return
+	}
+
+	w.c.ExtraFiles = []*os.File{pongr, pingw}
+	w.c.Args = append(w.c.Args, "peer", "3", "4", "1")
+	peer.c.ExtraFiles = []*os.File{pingr, pongw}
+	peer.c.Args = append(peer.c.Args, "peer", "3", "4", "0")
+
+	return
 }
 
 func (w *ProcessWorker) DumpLog(f io.Writer) (err error) {
@@ -68,7 +167,20 @@ func (w *ProcessWorker) DumpLog(f io.Writer) (err error) {
 }
 
 func (w *ProcessWorker) Process(report chan WorkerReport, done chan WorkerId) {
-	w.c.Start()
+	err := w.c.Start()
+	if err != nil {
+		fmt.Printf("Error starting worker %v: %v\n", w.id, err)
+	} else {
+		w.procLock.Lock()
+		w.proc = w.c.Process
+		w.procLock.Unlock()
+	}
+
+	// The child has its own copies now; close ours so that the
+	// peer sees EOF if this worker dies.
+	for _, f := range w.c.ExtraFiles {
+		f.Close()
+	}
 
 	scanner := bufio.NewScanner(w.stdout)
 
@@ -78,16 +190,10 @@ func (w *ProcessWorker) Process(report chan WorkerReport, done chan WorkerId) {
 		//fmt.Println("Got these bytes: ", s);
 		w.Log = append(w.Log, s)
 
-		if w.jsonStarted {
-			var r WorkerReport
-			json.Unmarshal([]byte(s), &r)
+		r, ok := w.proto.Parse(s)
+		if ok {
 			r.Id = w.id
 			report <- r
-		} else {
-			if s == "START JSON" {
-				//fmt.Println("Got token to start parsing json")
-				w.jsonStarted = true
-			}
 		}
 	}
 
@@ -0,0 +1,127 @@
+/*
+ * Copyright (C) 2016 George W. Dunlap, Citrix Systems UK Ltd
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation; version 2 of the
+ * License only.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * General Public License for more details.
+ * 
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301, USA.
+ */
+package main
+
+import (
+	"fmt"
+	"strings"
+	"strconv"
+	"encoding/json"
+	"hash/crc32"
+	"time"
+)
+
+// Worker output is a line protocol; each protocol line looks like:
+//
+//   SB [type] [seq] [crc32] [json]
+//
+// where crc32 is the IEEE crc32 of the json, in hex.  The first
+// protocol line is a "hello" with sequence number 0; after that
+// come "report"s, numbered from 1.  Anything else on the console
+// before the hello is ignored; anything after it is counted as
+// malformed.
+const WorkerProtocolVersion = 1
+
+type WorkerHello struct {
+	Version int
+	Caps []string
+	KHZ uint64 `json:"kHZ"`
+}
+
+type ProtocolParser struct {
+	Stats WorkerProtocol
+	helloSeen bool
+	lastSeq int
+}
+
+// Parse a line of worker output; ok is true if the line was a valid
+// report.
+func (p *ProtocolParser) Parse(s string) (r WorkerReport, ok bool) {
+	if !strings.HasPrefix(s, "SB ") {
+		if p.helloSeen {
+			p.Stats.Malformed++
+		} else {
+			p.Stats.Noise++
+		}
+		return
+	}
+
+	f := strings.SplitN(s, " ", 5)
+	if len(f) != 5 {
+		p.Stats.Malformed++
+		return
+	}
+
+	seq, err := strconv.Atoi(f[2])
+	if err != nil {
+		p.Stats.Malformed++
+		return
+	}
+
+	sum, err := strconv.ParseUint(f[3], 16, 32)
+	if err != nil {
+		p.Stats.Malformed++
+		return
+	}
+
+	if uint32(sum) != crc32.ChecksumIEEE([]byte(f[4])) {
+		p.Stats.BadChecksum++
+		return
+	}
+
+	switch f[1] {
+	case "hello":
+		var h WorkerHello
+		err = json.Unmarshal([]byte(f[4]), &h)
+		if err != nil || p.helloSeen {
+			p.Stats.Malformed++
+			return
+		}
+		p.helloSeen = true
+		p.lastSeq = seq
+		p.Stats.Version = h.Version
+		p.Stats.Caps = h.Caps
+		p.Stats.KHZ = h.KHZ
+		if h.Version != WorkerProtocolVersion {
+			p.Stats.Error = fmt.Sprintf("Unsupported protocol version %d (want %d)",
+				h.Version, WorkerProtocolVersion)
+		}
+	case "report":
+		if !p.helloSeen || p.Stats.Error != "" || seq <= p.lastSeq {
+			p.Stats.Malformed++
+			return
+		}
+		err = json.Unmarshal([]byte(f[4]), &r)
+		if err != nil {
+			p.Stats.Malformed++
+			return
+		}
+		if seq > p.lastSeq + 1 {
+			p.Stats.Gaps++
+			p.Stats.Missing += seq - p.lastSeq - 1
+		}
+		p.lastSeq = seq
+		p.Stats.Reports++
+		r.Recv = time.Now().UnixNano()
+		ok = true
+	default:
+		p.Stats.Malformed++
+	}
+	return
+}
@@ -23,10 +23,8 @@ import (
 	"os"
 	"os/signal"
 	"time"
-	"regexp"
-	"strconv"
-	"bufio"
 	"io"
+	"math"
 )
 
 type WorkerState struct {
@@ -40,6 +38,87 @@ type Worker interface {
 	Shutdown()
 	Process(chan WorkerReport, chan WorkerId)
 	DumpLog(io.Writer) error
+	// Only valid once Process has returned
+	Protocol() WorkerProtocol
+	// Connect this worker to a peer of the same type; this
+	// worker initiates.  Must be called after Init.
+	Pair(Worker) error
+	// Cpu time used so far, and the time spent in each runstate,
+	// using the snapshot if the backend needs one
+	Sample(*CpuSnapshot) (CpuSample, bool)
+}
+
+// Information about all domains, taken once per sample so that all
+// the workers are sampled at (nearly) the same time
+type CpuSnapshot struct {
+	taken bool
+	domains map[Domid]time.Duration
+	vcpus map[Domid][]Vcpuinfo
+}
+
+func (s *CpuSnapshot) Domain(id Domid) (cputime time.Duration, ok bool) {
+	if !s.taken {
+		s.taken = true
+		s.domains = make(map[Domid]time.Duration)
+		for _, di := range Ctx.ListDomain() {
+			s.domains[di.Domid] = di.Cpu_time
+		}
+	}
+	cputime, ok = s.domains[id]
+	return
+}
+
+func (s *CpuSnapshot) Vcpus(id Domid) []Vcpuinfo {
+	if s.vcpus == nil {
+		s.vcpus = make(map[Domid][]Vcpuinfo)
+	}
+	if _, ok := s.vcpus[id]; !ok {
+		s.vcpus[id] = Ctx.ListVcpu(id)
+	}
+	return s.vcpus[id]
+}
+
+const CpuSampleInterval = 100 * time.Millisecond
+
+func (ws *WorkerList) SampleCputime() (samples []CpuSample) {
+	var snap CpuSnapshot
+	for id := range *ws {
+		if s, ok := (*ws)[id].w.Sample(&snap); ok {
+			s.Id = id
+			samples = append(samples, s)
+		}
+	}
+	return
+}
+
+type SampleBatch struct {
+	Cpu []CpuSample
+	Pcpu PcpuSample
+	PcpuOk bool
+}
+
+// Sample the cpu time of all workers (and the idle time of the pool's
+// cpus, if pcpus is set) every CpuSampleInterval until quit is closed
+func (ws *WorkerList) Sampler(samples chan SampleBatch, pcpus *PcpuSampler, quit chan bool) {
+	ticker := time.NewTicker(CpuSampleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			var b SampleBatch
+			b.Cpu = ws.SampleCputime()
+			if pcpus != nil {
+				b.Pcpu, b.PcpuOk = pcpus.Sample()
+			}
+			select {
+			case samples <- b:
+			case <-quit:
+				return
+			}
+		case <-quit:
+			return
+		}
+	}
 }
 
 func Report(ws *WorkerState, r WorkerReport) {
@@ -118,40 +197,22 @@ func NewWorkerList(WorkerSets []WorkerSet, workerType int) (wl WorkerList, err e
 			wl[Id] = ws
 		}
 	}
-	return
-}
 
-var CpukHZ uint64
-
-func getCpuHz() (err error) {
-	if CpukHZ == 0 {
-		var cpuinfo *os.File
-		cpuinfo, err = os.Open("/proc/cpuinfo")
-		if err != nil {
-			return
+	for wsi := range WorkerSets {
+		if WorkerSets[wsi].PairWith == nil {
+			continue
 		}
-		re := regexp.MustCompile("^cpu MHz\\s*: ([0-9.]+)$")
-		scanner := bufio.NewScanner(cpuinfo)
-		for scanner.Scan() {
-			s := scanner.Text()
-			m := re.FindStringSubmatch(s)
-			if m != nil {
-				var MHZ float64
-				MHZ, err = strconv.ParseFloat(m[1], 64)
-				if err != nil {
-					return
-				}
-				CpukHZ = uint64(MHZ*1000)
-				break
+		peer := *WorkerSets[wsi].PairWith
+		for i := 0; i < WorkerSets[wsi].Count; i = i+1 {
+			Id := WorkerId{Set:wsi,Id:i}
+			PeerId := WorkerId{Set:peer,Id:i}
+
+			err = wl[Id].w.Pair(wl[PeerId].w)
+			if err != nil {
+				err = fmt.Errorf("Pairing %v with %v: %v", Id, PeerId, err)
+				return
 			}
 		}
-		if CpukHZ == 0 {
-			err = fmt.Errorf("Couldn't find cpu MHz")
-			return
-		} else {
-			fmt.Println("CpukHZ: ", CpukHZ)
-			
-		}
 	}
 	return
 }
@@ -328,7 +389,86 @@ func (run *BenchmarkRun) GetCpumap() (Cpumap Bitmap) {
 	return
 }
 
-func (run *BenchmarkRun) Run() (err error) {
+const (
+	StopReasonRuntime = "runtime"
+	StopReasonConverged = "converged"
+	StopReasonMax = "max runtime"
+	StopReasonWorkerExit = "worker exited"
+	StopReasonInterrupted = "interrupted"
+)
+
+// Need at least this many windows from each worker to say whether
+// it's settled
+const SteadyStateMinWindows = 5
+
+// Watches the reports of an adaptive run for when every worker's
+// throughput has settled
+type SteadyState struct {
+	config AdaptiveConfig
+	warm time.Time
+	last map[WorkerId]WorkerReport
+	tputs map[WorkerId][]float64
+}
+
+func NewSteadyState(sets []WorkerSet, config AdaptiveConfig) (s *SteadyState) {
+	s = &SteadyState{config:config,
+		warm:time.Now().Add(time.Duration(config.WarmupSeconds) * time.Second),
+		last:make(map[WorkerId]WorkerReport),
+		tputs:make(map[WorkerId][]float64)}
+	if s.config.CIWidth == 0 {
+		s.config.CIWidth = 0.05
+	}
+	for set := range sets {
+		for id := 0; id < sets[set].Count; id++ {
+			s.tputs[WorkerId{Set:set, Id:id}] = nil
+		}
+	}
+	return
+}
+
+// Add a report, and say whether all the workers have settled
+func (s *SteadyState) Add(r WorkerReport) (settled bool) {
+	l, ok := s.last[r.Id]
+	s.last[r.Id] = r
+	if !ok || time.Now().Before(s.warm) {
+		return
+	}
+	s.tputs[r.Id] = append(s.tputs[r.Id], Throughput(l.Now, l.Kops, r.Now, r.Kops))
+
+	for _, x := range s.tputs {
+		if len(x) < SteadyStateMinWindows {
+			return
+		}
+		mean := Mean(x)
+		if mean <= 0 {
+			return
+		}
+		// Normal approximation; consecutive windows aren't
+		// independent, so this is optimistic
+		width := 2 * 1.96 * SampleStdDev(x) / math.Sqrt(float64(len(x)))
+		if width / mean > s.config.CIWidth {
+			return
+		}
+	}
+	settled = true
+	return
+}
+
+func (run *BenchmarkRun) Run(workerType int) (err error) {
+	err = run.CheckPairs()
+	if err != nil {
+		return
+	}
+
+	run.Results.KHZ = CpukHZ
+	run.Results.KHZMethod = CpukHZMethod
+	if run.RunConfig.KHZ != 0 {
+		run.Results.KHZ = run.RunConfig.KHZ
+		run.Results.KHZMethod = "override"
+	}
+
+	run.Results.Host = run.CollectHostInfo(workerType)
+
 	for wsi := range run.WorkerSets {
 		conf := &run.WorkerSets[wsi].Config
 		
@@ -336,9 +476,9 @@ func (run *BenchmarkRun) Run() (err error) {
 		if conf.Pool == "" {
 			conf.Pool = run.RunConfig.Pool
 		}
-		run.WorkerSets[wsi].Params.SetkHZ(CpukHZ)
+		run.WorkerSets[wsi].Params.SetkHZ(run.Results.KHZ)
 		
-		if *run.RunConfig.NumaDisable {
+		if run.RunConfig.NumaDisable != nil && *run.RunConfig.NumaDisable {
 			if conf.SoftAffinity != "" {
 				err = fmt.Errorf("Cannot disable Numa if SoftAffinity is set!")
 				return
@@ -351,13 +491,22 @@ func (run *BenchmarkRun) Run() (err error) {
 		}
 	}
 	
-	Workers, err := NewWorkerList(run.WorkerSets, WorkerXen)
+	Workers, err := NewWorkerList(run.WorkerSets, workerType)
 	if err != nil {
 		fmt.Println("Error creating workers: %v", err)
+		Workers.Stop()
 		return
 
 	}
 	
+	pcpus, perr := run.NewPcpuSampler(workerType)
+	if perr != nil {
+		fmt.Printf("WARNING: Not sampling pcpu idle time: %v\n", perr)
+		pcpus = nil
+	} else {
+		run.Results.PoolCpus = pcpus.Cpus
+	}
+
 	report := make(chan WorkerReport)
 	done := make(chan WorkerId)
 	signals := make(chan os.Signal, 1)
@@ -366,10 +515,25 @@ func (run *BenchmarkRun) Run() (err error) {
 	
 	i := Workers.Start(report, done)
 
+	samples := make(chan SampleBatch)
+	quit := make(chan bool)
+	go Workers.Sampler(samples, pcpus, quit)
+	defer close(quit)
+
 	// FIXME:
 	// 1. Make a zero timeout mean "never"
 	// 2. Make the signals / timeout thing a bit more rational; signal then timeout shouldn't hard kill
 	timeout := time.After(time.Duration(run.RuntimeSeconds) * time.Second);
+	timeoutReason := StopReasonRuntime
+	var steady *SteadyState
+	if a := run.RunConfig.Adaptive; a != nil {
+		steady = NewSteadyState(run.WorkerSets, *a)
+		if a.MaxSeconds > 0 {
+			timeout = time.After(time.Duration(a.MaxSeconds) * time.Second)
+			timeoutReason = StopReasonMax
+		}
+	}
+	start := time.Now()
 	stopped := false
 	for i > 0 {
 		select {
@@ -377,6 +541,21 @@ func (run *BenchmarkRun) Run() (err error) {
 			if ! stopped {
 				run.Results.Raw = append(run.Results.Raw, r)
 				Report(Workers[r.Id], r)
+				if steady != nil && steady.Add(r) {
+					fmt.Printf("Throughput settled after %v, stopping\n",
+						time.Since(start))
+					Workers.Stop()
+					stopped = true
+					run.Completed = true
+					run.Results.StopReason = StopReasonConverged
+				}
+			}
+		case b := <-samples:
+			if ! stopped {
+				run.Results.CpuSamples = append(run.Results.CpuSamples, b.Cpu...)
+				if b.PcpuOk {
+					run.Results.PcpuSamples = append(run.Results.PcpuSamples, b.Pcpu)
+				}
 			}
 		case did := <-done:
 			if ! stopped {
@@ -384,6 +563,7 @@ func (run *BenchmarkRun) Run() (err error) {
 				Workers.Stop()
 				stopped = true
 				err = fmt.Errorf("Worker %v exited early", did)
+				run.Results.StopReason = StopReasonWorkerExit
 				Workers[did].w.DumpLog(os.Stdout)
 			}
 			i--;
@@ -393,6 +573,7 @@ func (run *BenchmarkRun) Run() (err error) {
 				Workers.Stop()
 				stopped = true
 				run.Completed = true
+				run.Results.StopReason = timeoutReason
 			}
 		case <-signals:
 			if ! stopped {
@@ -403,17 +584,163 @@ func (run *BenchmarkRun) Run() (err error) {
 					run.Completed = true
 				}
 				err = fmt.Errorf("Interrupted")
+				run.Results.StopReason = StopReasonInterrupted
 			} else {
 				fmt.Println("SIGINT received after stop, exiting without cleaning up")
 				return
 			}
 		}
 	}
+
+	run.Results.Protocol = nil
+	for wsi := range run.WorkerSets {
+		for i := 0; i < run.WorkerSets[wsi].Count; i = i+1 {
+			p := Workers[WorkerId{Set:wsi,Id:i}].w.Protocol()
+			if p.KHZ != 0 && p.KHZ != run.Results.KHZ && p.Error == "" {
+				p.Error = fmt.Sprintf("worker used kHZ %d, expected %d",
+					p.KHZ, run.Results.KHZ)
+			}
+			if problems := p.Problems(); problems != "" {
+				fmt.Printf("WARNING: Worker %v protocol: %s\n", p.Id, problems)
+			}
+			run.Results.Protocol = append(run.Results.Protocol, p)
+		}
+	}
 	return
 }
 
-func (plan *BenchmarkPlan) Run() (err error) {
+// Run the worker sets by themselves under the plan-wide config, and
+// return the summary for each set.
+func (plan *BenchmarkPlan) calibrationRun(label string, sets []WorkerSet) (summary []WorkerSetSummary, err error) {
+	run := BenchmarkRun{
+		Label: label,
+		WorkerSets: sets,
+		RuntimeSeconds: 10,
+	}
+	run.WorkerConfig.PropagateFrom(plan.WorkerConfig)
+	run.RunConfig.PropagateFrom(plan.RunConfig)
+	// If the pool needs to be created we need a scheduler; use
+	// the first run's.
+	if run.RunConfig.Scheduler == "" && len(plan.Runs) > 0 {
+		run.RunConfig.Scheduler = plan.Runs[0].RunConfig.Scheduler
+	}
+
+	for set := range run.WorkerSets {
+		err = run.WorkerSets[set].Params.ResolveUnits(plan.Calibration.NsPerKop)
+		if err != nil {
+			return
+		}
+	}
+
+	ready, why := run.Prep()
+	if !ready {
+		err = fmt.Errorf("Calibration %s can't run (%s)", label, why)
+		return
+	}
+
+	fmt.Printf("Calibrating %s\n", label)
+	err = run.Run(plan.WorkerType)
+	if err != nil {
+		return
+	}
+
+	err = run.Process()
+	if err != nil {
+		return
+	}
 
+	for set := range run.Results.Summary {
+		if len(run.Results.Summary[set].Workers) == 0 {
+			err = fmt.Errorf("Calibration %s: no reports from set %d", label, set)
+			return
+		}
+	}
+	summary = run.Results.Summary
+	return
+}
+
+// Measure the raw burn rate, then run each preset by itself (paired
+// presets with their partner) to find its uncontended throughput and
+// duty cycle.  Only things not already calibrated are run.
+func (plan *BenchmarkPlan) Calibrate() (err error) {
+	if plan.Calibration == nil {
+		plan.Calibration = &PlanCalibration{}
+	}
+	cal := plan.Calibration
+
+	if cal.NsPerKop == 0 {
+		var summary []WorkerSetSummary
+		summary, err = plan.calibrationRun("burn rate",
+			[]WorkerSet{{Params:WorkerParams{[]string{"burnwait", "100", "0"}}, Count:1}})
+		if err != nil {
+			return
+		}
+		s := &summary[0].Workers[0]
+		cal.NsPerKop = s.AvgUtil * SEC / s.AvgTput
+		fmt.Printf("Burn rate: %.2f ns/kop\n", cal.NsPerKop)
+	}
+
+	if plan.Input == nil || plan.Input.SimpleMatrix == nil {
+		return
+	}
+
+	if cal.Presets == nil {
+		cal.Presets = make(map[string]PresetCalibration)
+	}
+
+	pairs := plan.Input.SimpleMatrix.Pairs
+	responder := make(map[string]string)
+	for pi, pr := range pairs {
+		responder[pr] = pi
+	}
+
+	for _, wn := range plan.Input.SimpleMatrix.Workers {
+		if _, ok := responder[wn]; ok {
+			continue
+		}
+		if _, ok := cal.Presets[wn]; ok {
+			continue
+		}
+
+		var wp WorkerParams
+		wp, err = plan.lookupPreset(wn)
+		if err != nil {
+			return
+		}
+
+		names := []string{wn}
+		sets := []WorkerSet{{Params:wp, Count:1, Preset:wn}}
+		if pr, ok := pairs[wn]; ok {
+			var pp WorkerParams
+			pp, err = plan.lookupPreset(pr)
+			if err != nil {
+				return
+			}
+			sets[0].PairWith = new(int)
+			*sets[0].PairWith = 1
+			sets = append(sets, WorkerSet{Params:pp, Count:1, Preset:pr})
+			names = append(names, pr)
+		}
+
+		var summary []WorkerSetSummary
+		summary, err = plan.calibrationRun("preset "+wn, sets)
+		if err != nil {
+			return
+		}
+
+		for set := range summary {
+			s := &summary[set].Workers[0]
+			cal.Presets[names[set]] = PresetCalibration{
+				Tput: s.AvgTput,
+				NsPerKop: s.AvgUtil * SEC / s.AvgTput,
+				DutyCycle: s.AvgUtil,
+			}
+		}
+	}
+	return
+}
+
+func (plan *BenchmarkPlan) setup() (err error) {
 	err = getCpuHz()
 	if err != nil {
 		return
@@ -424,6 +751,45 @@ func (plan *BenchmarkPlan) Run() (err error) {
 		if err != nil {
 			return
 		}
+		err = Xch.Open()
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Throw away any existing calibration and calibrate again
+func (plan *BenchmarkPlan) RunCalibration() (err error) {
+	err = plan.setup()
+	if err != nil {
+		return
+	}
+
+	plan.Calibration = nil
+	err = plan.Calibrate()
+	if err != nil {
+		return
+	}
+
+	err = plan.Save()
+	return
+}
+
+func (plan *BenchmarkPlan) Run() (err error) {
+	err = plan.setup()
+	if err != nil {
+		return
+	}
+	
+	err = plan.Calibrate()
+	if err != nil {
+		return
+	}
+	err = plan.Save()
+	if err != nil {
+		fmt.Println("Error saving: ", err)
+		return
 	}
 	
 	for i := range plan.Runs {
@@ -431,10 +797,16 @@ func (plan *BenchmarkPlan) Run() (err error) {
 		if ! r.Completed { 
 			r.WorkerConfig.PropagateFrom(plan.WorkerConfig)
 			r.RunConfig.PropagateFrom(plan.RunConfig)
+			for set := range r.WorkerSets {
+				err = r.WorkerSets[set].Params.ResolveUnits(plan.Calibration.NsPerKop)
+				if err != nil {
+					return
+				}
+			}
 			ready, why := r.Prep()
 			if ready {
 				fmt.Printf("Running test [%d] %s\n", i, r.Label)
-				err = r.Run()
+				err = r.Run(plan.WorkerType)
 				if err != nil {
 					return
 				}
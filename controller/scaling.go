@@ -0,0 +1,191 @@
+/*
+ * Copyright (C) 2016 George W. Dunlap, Citrix Systems UK Ltd
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation; version 2 of the
+ * License only.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301, USA.
+ */
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// The set metrics (from SetMetrics) plotted against the number of
+// workers
+var ScalingMetrics = []string{"ttotal", "tavgavg", "uavgavg", "jain"}
+
+// One point of a scaling curve: the mean of the repeats of a
+// configuration
+type ScalingPoint struct {
+	Workers int
+	// Workers per pool cpu
+	Load float64
+	Group *RunGroup
+}
+
+// Runs which differ only in the number of workers and the
+// scheduler, with one series per scheduler
+type ScalingCurve struct {
+	Key string
+	Sets int
+	Schedulers []string
+	// Worker counts, sorted
+	Workers []int
+	// By scheduler, then by worker count
+	Points map[string]map[int]ScalingPoint
+}
+
+func findSetMetric(name string) int {
+	for m := range SetMetrics {
+		if SetMetrics[m].Name == name {
+			return m
+		}
+	}
+	return -1
+}
+
+// Must be called after Process
+func (plan *BenchmarkPlan) ScalingCurves() (curves []ScalingCurve) {
+	index := make(map[string]int)
+	groups := plan.GroupRuns()
+	for g := range groups {
+		grp := &groups[g]
+		r := &plan.Runs[grp.Runs[0]]
+		if r.Baseline {
+			continue
+		}
+		key := r.ConfigKey("count", "scheduler")
+		c, ok := index[key]
+		if !ok {
+			c = len(curves)
+			index[key] = c
+			curves = append(curves, ScalingCurve{Key:key, Sets:len(grp.Sets),
+				Points:make(map[string]map[int]ScalingPoint)})
+		}
+		curve := &curves[c]
+
+		sched := r.RunConfig.Scheduler
+		if sched == "" {
+			sched = "default"
+		}
+		if curve.Points[sched] == nil {
+			curve.Points[sched] = make(map[int]ScalingPoint)
+			curve.Schedulers = append(curve.Schedulers, sched)
+		}
+		p := ScalingPoint{Workers:r.WorkerCount(), Group:grp}
+		if cpus := r.poolCpuCount(plan); cpus > 0 {
+			p.Load = float64(p.Workers) / float64(cpus)
+		}
+		curve.Points[sched][p.Workers] = p
+	}
+
+	for c := range curves {
+		curve := &curves[c]
+		seen := make(map[int]bool)
+		for _, points := range curve.Points {
+			for n := range points {
+				if !seen[n] {
+					seen[n] = true
+					curve.Workers = append(curve.Workers, n)
+				}
+			}
+		}
+		sort.Ints(curve.Workers)
+		sort.Strings(curve.Schedulers)
+	}
+	return
+}
+
+// The mean of metric m of the set at each worker count, for each
+// scheduler; NaN where there's no run.  ok is false if the metric
+// wasn't calculated at all.
+func (curve *ScalingCurve) Series(set int, m int) (y [][]float64, ok bool) {
+	for _, sched := range curve.Schedulers {
+		var s []float64
+		for _, n := range curve.Workers {
+			p, present := curve.Points[sched][n]
+			if !present || set >= len(p.Group.Sets) {
+				s = append(s, math.NaN())
+				continue
+			}
+			v := p.Group.Sets[set][m].Mean
+			if v != 0 {
+				ok = true
+			}
+			s = append(s, v)
+		}
+		y = append(y, s)
+	}
+	return
+}
+
+// The load of each worker count, if known
+func (curve *ScalingCurve) Loads() (loads []float64) {
+	for _, n := range curve.Workers {
+		load := 0.0
+		for _, sched := range curve.Schedulers {
+			if p, ok := curve.Points[sched][n]; ok {
+				load = p.Load
+			}
+		}
+		loads = append(loads, load)
+	}
+	return
+}
+
+func (plan *BenchmarkPlan) ScalingReport(level int) (err error) {
+	err = plan.Process()
+	if err != nil {
+		return
+	}
+
+	for _, curve := range plan.ScalingCurves() {
+		if len(curve.Workers) < 2 {
+			continue
+		}
+		fmt.Printf("== SCALING %s ==\n", curve.Key)
+		loads := curve.Loads()
+		for set := 0; set < curve.Sets; set++ {
+			for _, name := range ScalingMetrics {
+				m := findSetMetric(name)
+				y, ok := curve.Series(set, m)
+				if !ok {
+					continue
+				}
+				fmt.Printf("\nset %d %s\n", set, name)
+				fmt.Printf("%8s %8s", "workers", "load")
+				for _, sched := range curve.Schedulers {
+					fmt.Printf(" %8s", sched)
+				}
+				fmt.Printf("\n")
+				for i, n := range curve.Workers {
+					fmt.Printf("%8d %8.2f", n, loads[i])
+					for s := range curve.Schedulers {
+						if math.IsNaN(y[s][i]) {
+							fmt.Printf(" %8s", "-")
+						} else {
+							fmt.Printf(" %8.2f", y[s][i])
+						}
+					}
+					fmt.Printf("\n")
+				}
+			}
+		}
+		fmt.Printf("\n")
+	}
+	return
+}
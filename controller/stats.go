@@ -0,0 +1,283 @@
+/*
+ * Copyright (C) 2016 George W. Dunlap, Citrix Systems UK Ltd
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation; version 2 of the
+ * License only.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * General Public License for more details.
+ * 
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301, USA.
+ */
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+func Mean(x []float64) (mean float64) {
+	for _, v := range x {
+		mean += v
+	}
+	mean /= float64(len(x))
+	return
+}
+
+// Population standard deviation, as used for AvgStdDev*
+func StdDev(x []float64) float64 {
+	mean := Mean(x)
+	var ss float64
+	for _, v := range x {
+		ss += (v - mean) * (v - mean)
+	}
+	return math.Sqrt(ss / float64(len(x)))
+}
+
+// Sample standard deviation, for estimating the spread of repeated
+// measurements
+func SampleStdDev(x []float64) float64 {
+	if len(x) < 2 {
+		return 0
+	}
+	n := float64(len(x))
+	return StdDev(x) * math.Sqrt(n / (n - 1))
+}
+
+func Median(x []float64) float64 {
+	sorted := make([]float64, len(x))
+	copy(sorted, x)
+	sort.Float64s(sorted)
+	return Percentile(sorted, 50)
+}
+
+// Percentile p (0-100) of already-sorted samples, interpolating
+// linearly between the closest ranks.
+func Percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	r := p / 100 * float64(len(sorted) - 1)
+	lo := int(math.Floor(r))
+	hi := int(math.Ceil(r))
+	return sorted[lo] + (sorted[hi] - sorted[lo]) * (r - float64(lo))
+}
+
+type Distribution struct {
+	P1  float64
+	P5  float64
+	P50 float64
+	P95 float64
+	P99 float64
+	// Interquartile range, and coefficient of variation
+	// (stddev / mean)
+	IQR float64
+	CoV float64
+}
+
+func NewDistribution(samples []float64) (d Distribution) {
+	if len(samples) == 0 {
+		return
+	}
+
+	sorted := make([]float64, len(samples))
+	copy(sorted, samples)
+	sort.Float64s(sorted)
+
+	d.P1 = Percentile(sorted, 1)
+	d.P5 = Percentile(sorted, 5)
+	d.P50 = Percentile(sorted, 50)
+	d.P95 = Percentile(sorted, 95)
+	d.P99 = Percentile(sorted, 99)
+	d.IQR = Percentile(sorted, 75) - Percentile(sorted, 25)
+	if mean := Mean(samples); mean != 0 {
+		d.CoV = StdDev(samples) / mean
+	}
+	return
+}
+
+func (d *Distribution) TextReport(id string, name string) {
+	fmt.Printf("%s %8s %8.2f %8.2f %8.2f %8.2f %8.2f %8.2f %8.2f\n",
+		id, name, d.P1, d.P5, d.P50, d.P95, d.P99, d.IQR, d.CoV)
+}
+
+// 95% confidence interval for the mean, by bootstrap resampling.
+// The generator is seeded so that reports are repeatable.
+func BootstrapCI(x []float64) (lo float64, hi float64) {
+	if len(x) == 0 {
+		return
+	}
+	if len(x) == 1 {
+		return x[0], x[0]
+	}
+
+	const resamples = 1000
+	rng := rand.New(rand.NewSource(1))
+	means := make([]float64, resamples)
+	for i := range means {
+		var sum float64
+		for range x {
+			sum += x[rng.Intn(len(x))]
+		}
+		means[i] = sum / float64(len(x))
+	}
+	sort.Float64s(means)
+	lo = Percentile(means, 2.5)
+	hi = Percentile(means, 97.5)
+	return
+}
+
+// Regularized incomplete beta function I_x(a, b), by continued
+// fraction (Numerical Recipes, 6.4)
+func IncompleteBeta(x float64, a float64, b float64) float64 {
+	if x <= 0 {
+		return 0
+	}
+	if x >= 1 {
+		return 1
+	}
+	la, _ := math.Lgamma(a)
+	lb, _ := math.Lgamma(b)
+	lab, _ := math.Lgamma(a + b)
+	front := math.Exp(lab - la - lb + a * math.Log(x) + b * math.Log(1 - x))
+	if x < (a + 1) / (a + b + 2) {
+		return front * betaCF(x, a, b) / a
+	}
+	return 1 - front * betaCF(1 - x, b, a) / b
+}
+
+func betaCF(x float64, a float64, b float64) float64 {
+	const tiny = 1e-300
+	c := 1.0
+	d := 1 - (a + b) * x / (a + 1)
+	if math.Abs(d) < tiny {
+		d = tiny
+	}
+	d = 1 / d
+	h := d
+	for m := 1; m <= 300; m++ {
+		fm := float64(m)
+		for i := 0; i < 2; i++ {
+			var aa float64
+			if i == 0 {
+				aa = fm * (b - fm) * x / ((a + 2 * fm - 1) * (a + 2 * fm))
+			} else {
+				aa = -(a + fm) * (a + b + fm) * x / ((a + 2 * fm) * (a + 2 * fm + 1))
+			}
+			d = 1 + aa * d
+			if math.Abs(d) < tiny {
+				d = tiny
+			}
+			c = 1 + aa / c
+			if math.Abs(c) < tiny {
+				c = tiny
+			}
+			d = 1 / d
+			h *= d * c
+			if i == 1 && math.Abs(d * c - 1) < 1e-12 {
+				return h
+			}
+		}
+	}
+	return h
+}
+
+// Welch's unequal-variance t-test; returns the two-sided p-value.
+func WelchTTest(a []float64, b []float64) (p float64) {
+	p = 1
+	if len(a) < 2 || len(b) < 2 {
+		return
+	}
+	na, nb := float64(len(a)), float64(len(b))
+	va := SampleStdDev(a) * SampleStdDev(a) / na
+	vb := SampleStdDev(b) * SampleStdDev(b) / nb
+	if va + vb == 0 {
+		if Mean(a) != Mean(b) {
+			p = 0
+		}
+		return
+	}
+	t := (Mean(a) - Mean(b)) / math.Sqrt(va + vb)
+	df := (va + vb) * (va + vb) / (va * va / (na - 1) + vb * vb / (nb - 1))
+	p = IncompleteBeta(df / (df + t * t), df / 2, 0.5)
+	return
+}
+
+// Mann-Whitney U test, using the normal approximation with a
+// correction for ties; returns the two-sided p-value, and Cliff's
+// delta (the probability that a sample from b is bigger than one from
+// a, less the probability that it's smaller).
+func MannWhitney(a []float64, b []float64) (p float64, delta float64) {
+	p = 1
+	if len(a) == 0 || len(b) == 0 {
+		return
+	}
+
+	type sample struct {
+		v float64
+		fromB bool
+	}
+	all := make([]sample, 0, len(a) + len(b))
+	for _, v := range a {
+		all = append(all, sample{v, false})
+	}
+	for _, v := range b {
+		all = append(all, sample{v, true})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].v < all[j].v })
+
+	// Average the ranks of ties
+	var rankB, ties float64
+	for i := 0; i < len(all); {
+		j := i
+		for j < len(all) && all[j].v == all[i].v {
+			j++
+		}
+		rank := float64(i + j + 1) / 2
+		for k := i; k < j; k++ {
+			if all[k].fromB {
+				rankB += rank
+			}
+		}
+		t := float64(j - i)
+		ties += t * t * t - t
+		i = j
+	}
+
+	na, nb := float64(len(a)), float64(len(b))
+	n := na + nb
+	u := rankB - nb * (nb + 1) / 2
+	delta = 2 * u / (na * nb) - 1
+
+	sigma := math.Sqrt(na * nb / 12 * ((n + 1) - ties / (n * (n - 1))))
+	if sigma == 0 {
+		return
+	}
+	z := (u - na * nb / 2) / sigma
+	p = math.Erfc(math.Abs(z) / math.Sqrt2)
+	return
+}
+
+// Cohen's d: the difference in means (b - a) in units of the pooled
+// standard deviation
+func CohenD(a []float64, b []float64) float64 {
+	if len(a) < 2 || len(b) < 2 {
+		return 0
+	}
+	na, nb := float64(len(a)), float64(len(b))
+	sa, sb := SampleStdDev(a), SampleStdDev(b)
+	s := math.Sqrt(((na - 1) * sa * sa + (nb - 1) * sb * sb) / (na + nb - 2))
+	if s == 0 {
+		return 0
+	}
+	return (Mean(b) - Mean(a)) / s
+}
@@ -28,6 +28,12 @@ func (plan *BenchmarkPlan) Run() (err error) {
 	return
 }
 
+func (plan *BenchmarkPlan) RunCalibration() (err error) {
+	err = fmt.Errorf("Not implemented")
+
+	return
+}
+
 func XlTest(Args []string) {
 	return
 }
@@ -0,0 +1,205 @@
+/*
+ * Copyright (C) 2016 George W. Dunlap, Citrix Systems UK Ltd
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation; version 2 of the
+ * License only.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301, USA.
+ */
+package main
+
+/*
+ * Charts drawn as inline SVG, so that the html report doesn't need
+ * anything from the network.
+ */
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"math"
+)
+
+const (
+	svgWidth = 1000
+	svgHeight = 600
+	svgLeft = 80
+	svgRight = 180
+	svgTop = 40
+	svgBottom = 50
+)
+
+var svgColors = []string{
+	"#3366cc", "#dc3912", "#ff9900", "#109618", "#990099",
+	"#0099c6", "#dd4477", "#66aa00", "#b82e2e", "#316395",
+}
+
+// A step of 1, 2 or 5 times a power of ten giving about n ticks
+// between lo and hi
+func niceStep(lo float64, hi float64, n int) float64 {
+	span := hi - lo
+	if span <= 0 {
+		return 1
+	}
+	raw := span / float64(n)
+	mag := math.Pow(10, math.Floor(math.Log10(raw)))
+	for _, m := range []float64{1, 2, 5} {
+		if m * mag >= raw {
+			return m * mag
+		}
+	}
+	return 10 * mag
+}
+
+type SVGChart struct {
+	Title string
+	hTitle string
+	vTitle string
+	Names []string
+	// A series per name; points with a NaN y are left out (and
+	// break the line)
+	Series [][]Point
+	// Join the points of each series, rather than just marking
+	// them
+	Lines bool
+	// Bars, grouped by x, rather than points; x is then the
+	// index of the group, labelled with Groups
+	Bars bool
+	Groups []string
+}
+
+func (c *SVGChart) color(i int) string {
+	return svgColors[i % len(svgColors)]
+}
+
+func (c *SVGChart) Output(w io.Writer) (err error) {
+	// The y axis always includes 0
+	xmin, xmax := math.Inf(1), math.Inf(-1)
+	ymin, ymax := 0.0, 0.0
+	for _, s := range c.Series {
+		for _, p := range s {
+			if math.IsNaN(p.y) {
+				continue
+			}
+			xmin = math.Min(xmin, p.x)
+			xmax = math.Max(xmax, p.x)
+			ymax = math.Max(ymax, p.y)
+			ymin = math.Min(ymin, p.y)
+		}
+	}
+	if math.IsInf(xmin, 1) {
+		xmin, xmax = 0, 1
+	}
+	if c.Bars {
+		xmin, xmax = -0.5, float64(len(c.Groups)) - 0.5
+	} else if xmax == xmin {
+		xmin, xmax = xmin - 1, xmax + 1
+	}
+	if ymax == ymin {
+		ymax = ymin + 1
+	}
+	ystep := niceStep(ymin, ymax, 8)
+	ymax = math.Ceil(ymax / ystep) * ystep
+	ymin = math.Floor(ymin / ystep) * ystep
+
+	pw := float64(svgWidth - svgLeft - svgRight)
+	ph := float64(svgHeight - svgTop - svgBottom)
+	px := func(x float64) float64 { return svgLeft + (x - xmin) / (xmax - xmin) * pw }
+	py := func(y float64) float64 { return svgTop + ph - (y - ymin) / (ymax - ymin) * ph }
+
+	fmt.Fprintf(w, "    <svg class='chart' viewBox='0 0 %d %d' xmlns='http://www.w3.org/2000/svg'>\n", svgWidth, svgHeight)
+	fmt.Fprintf(w, "      <text x='%d' y='%d' text-anchor='middle' font-weight='bold'>%s</text>\n",
+		svgWidth / 2, svgTop / 2, html.EscapeString(c.Title))
+
+	// Grid and axes
+	for k := 0; ymin + float64(k) * ystep <= ymax + ystep / 2; k++ {
+		// Multiply rather than add, to keep the labels round
+		y := ymin + float64(k) * ystep
+		fmt.Fprintf(w, "      <line x1='%d' y1='%.1f' x2='%d' y2='%.1f' stroke='#ddd'/>\n",
+			svgLeft, py(y), svgWidth - svgRight, py(y))
+		fmt.Fprintf(w, "      <text x='%d' y='%.1f' text-anchor='end' font-size='12'>%g</text>\n",
+			svgLeft - 5, py(y) + 4, y)
+	}
+	if c.Bars {
+		for g, name := range c.Groups {
+			fmt.Fprintf(w, "      <text x='%.1f' y='%d' text-anchor='middle' font-size='12'>%s</text>\n",
+				px(float64(g)), svgHeight - svgBottom + 16, html.EscapeString(name))
+		}
+	} else {
+		xstep := niceStep(xmin, xmax, 10)
+		for k := math.Ceil(xmin / xstep); k * xstep <= xmax; k++ {
+			x := k * xstep
+			fmt.Fprintf(w, "      <line x1='%.1f' y1='%d' x2='%.1f' y2='%d' stroke='#ddd'/>\n",
+				px(x), svgTop, px(x), svgHeight - svgBottom)
+			fmt.Fprintf(w, "      <text x='%.1f' y='%d' text-anchor='middle' font-size='12'>%g</text>\n",
+				px(x), svgHeight - svgBottom + 16, x)
+		}
+	}
+	fmt.Fprintf(w, "      <rect x='%d' y='%d' width='%.0f' height='%.0f' fill='none' stroke='#666'/>\n",
+		svgLeft, svgTop, pw, ph)
+	fmt.Fprintf(w, "      <text x='%.0f' y='%d' text-anchor='middle'>%s</text>\n",
+		svgLeft + pw / 2, svgHeight - 10, html.EscapeString(c.hTitle))
+	fmt.Fprintf(w, "      <text x='15' y='%.0f' text-anchor='middle' transform='rotate(-90 15 %.0f)'>%s</text>\n",
+		svgTop + ph / 2, svgTop + ph / 2, html.EscapeString(c.vTitle))
+
+	// Data.  Bars in each group take up 80% of the space between
+	// groups.
+	barWidth := 0.8 / float64(len(c.Series))
+	for i, s := range c.Series {
+		color := c.color(i)
+		fmt.Fprintf(w, "      <g fill='%s' stroke='%s'>\n", color, color)
+		var line []string
+		flush := func() {
+			if len(line) > 1 {
+				fmt.Fprintf(w, "        <polyline fill='none' stroke-width='2' points='")
+				for _, p := range line {
+					fmt.Fprintf(w, "%s ", p)
+				}
+				fmt.Fprintf(w, "'/>\n")
+			}
+			line = nil
+		}
+		for _, p := range s {
+			if math.IsNaN(p.y) {
+				flush()
+				continue
+			}
+			switch {
+			case c.Bars:
+				x := px(p.x - 0.4 + barWidth * float64(i))
+				fmt.Fprintf(w, "        <rect x='%.1f' y='%.1f' width='%.1f' height='%.1f' fill-opacity='0.7'><title>%s: %g</title></rect>\n",
+					x, math.Min(py(p.y), py(0)), barWidth / (xmax - xmin) * pw,
+					math.Abs(py(0) - py(p.y)), html.EscapeString(c.Names[i]), p.y)
+			case c.Lines:
+				line = append(line, fmt.Sprintf("%.1f,%.1f", px(p.x), py(p.y)))
+				fmt.Fprintf(w, "        <circle cx='%.1f' cy='%.1f' r='3'/>\n", px(p.x), py(p.y))
+			default:
+				fmt.Fprintf(w, "        <circle cx='%.1f' cy='%.1f' r='2' fill-opacity='0.7' stroke='none'/>\n", px(p.x), py(p.y))
+			}
+		}
+		flush()
+		fmt.Fprintf(w, "      </g>\n")
+	}
+
+	// Legend
+	for i, name := range c.Names {
+		y := svgTop + 10 + i * 18
+		fmt.Fprintf(w, "      <rect x='%d' y='%d' width='10' height='10' fill='%s'/>\n",
+			svgWidth - svgRight + 15, y, c.color(i))
+		fmt.Fprintf(w, "      <text x='%d' y='%d' font-size='12'>%s</text>\n",
+			svgWidth - svgRight + 30, y + 10, html.EscapeString(name))
+	}
+
+	fmt.Fprintf(w, "    </svg>\n")
+	return
+}
@@ -0,0 +1,170 @@
+/*
+ * Copyright (C) 2016 George W. Dunlap, Citrix Systems UK Ltd
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation; version 2 of the
+ * License only.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * General Public License for more details.
+ * 
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301, USA.
+ */
+package main
+
+/*
+#define _GNU_SOURCE
+#include <stdint.h>
+#include <cpuid.h>
+#include <sched.h>
+
+static int tsc_cpuid(unsigned int leaf, unsigned int *a, unsigned int *b,
+                     unsigned int *c, unsigned int *d)
+{
+    if (__get_cpuid_max(0, NULL) < leaf)
+        return 0;
+    __cpuid_count(leaf, 0, *a, *b, *c, *d);
+    return 1;
+}
+
+static uint64_t tsc_rdtsc(void)
+{
+    uint32_t low, high;
+
+    __asm__ __volatile__("rdtsc" : "=a" (low), "=d" (high));
+
+    return ((uint64_t)high << 32) | low;
+}
+
+// Keep the calling thread on the cpu it's on now, so that all the
+// rdtscs read the same TSC; tsc_unpin puts the old affinity back.
+static cpu_set_t tsc_saved;
+
+static int tsc_pin(void)
+{
+    cpu_set_t set;
+    int cpu = sched_getcpu();
+
+    if (cpu < 0 || sched_getaffinity(0, sizeof(tsc_saved), &tsc_saved))
+        return -1;
+    CPU_ZERO(&set);
+    CPU_SET(cpu, &set);
+    return sched_setaffinity(0, sizeof(set), &set);
+}
+
+static void tsc_unpin(void)
+{
+    sched_setaffinity(0, sizeof(tsc_saved), &tsc_saved);
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"time"
+)
+
+// The workers convert rdtsc to ns using the kHZ we give them, so what
+// we want is the TSC frequency, not whatever frequency the cpu
+// happens to be running at right now (which is what /proc/cpuinfo
+// reports).
+var CpukHZ uint64
+var CpukHZMethod string
+
+func cpuid(leaf uint) (a, b, c, d uint32, ok bool) {
+	var ca, cb, cc, cd C.uint
+	if C.tsc_cpuid(C.uint(leaf), &ca, &cb, &cc, &cd) == 0 {
+		return
+	}
+	return uint32(ca), uint32(cb), uint32(cc), uint32(cd), true
+}
+
+// Leaf 0x15 gives the TSC / crystal ratio, and (sometimes) the
+// crystal frequency; leaf 0x16 gives the base frequency, which is
+// what the TSC runs at if the crystal isn't enumerated.
+func tscFromCpuid() (kHZ uint64, method string) {
+	a, b, c, _, ok := cpuid(0x15)
+	if ok && a != 0 && b != 0 && c != 0 {
+		kHZ = uint64(c) * uint64(b) / uint64(a) / 1000
+		method = "cpuid-0x15"
+		return
+	}
+
+	a, _, _, _, ok = cpuid(0x16)
+	if ok && a != 0 {
+		kHZ = uint64(a) * 1000
+		method = "cpuid-0x16"
+	}
+	return
+}
+
+// Count TSC ticks against the (monotonic) clock a few times and take
+// the median.  The thread is kept on one cpu throughout, in case the
+// TSCs of different cpus don't agree.
+func tscCalibrate() (kHZ uint64) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if C.tsc_pin() != 0 {
+		fmt.Printf("WARNING: Couldn't pin to a cpu to calibrate the TSC\n")
+	} else {
+		defer C.tsc_unpin()
+	}
+
+	var samples []uint64
+	for i := 0; i < 5; i++ {
+		t0 := time.Now()
+		c0 := uint64(C.tsc_rdtsc())
+		time.Sleep(100 * time.Millisecond)
+		c1 := uint64(C.tsc_rdtsc())
+		t1 := time.Now()
+
+		samples = append(samples, (c1 - c0) * uint64(MSEC) / uint64(t1.Sub(t0)))
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	return samples[len(samples)/2]
+}
+
+func getCpuHz() (err error) {
+	if CpukHZ != 0 {
+		return
+	}
+
+	measured := tscCalibrate()
+	if measured == 0 {
+		err = fmt.Errorf("Couldn't measure TSC frequency")
+		return
+	}
+
+	CpukHZ, CpukHZMethod = tscFromCpuid()
+
+	// CPUID may be lying to us (for instance, if we're in a
+	// guest); if it's more than 1% off what we measured, don't
+	// believe it.
+	if CpukHZ != 0 {
+		diff := float64(CpukHZ) - float64(measured)
+		if diff < 0 {
+			diff = -diff
+		}
+		if diff / float64(measured) > 0.01 {
+			fmt.Printf("WARNING: %s says TSC is %d kHZ, but measured %d kHZ; using measured\n",
+				CpukHZMethod, CpukHZ, measured)
+			CpukHZ = 0
+		}
+	}
+
+	if CpukHZ == 0 {
+		CpukHZ = measured
+		CpukHZMethod = "calibrated"
+	}
+
+	fmt.Printf("CpukHZ: %d (%s)\n", CpukHZ, CpukHZMethod)
+	return
+}
@@ -0,0 +1,89 @@
+/*
+ * Copyright (C) 2016 George W. Dunlap, Citrix Systems UK Ltd
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation; version 2 of the
+ * License only.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301, USA.
+ */
+package main
+
+/*
+ * libxl doesn't expose per-pcpu idle time, so go to libxenctrl for
+ * that.  (Linked with -lxenctrl along with libxl.)
+ */
+
+/*
+#include <stdlib.h>
+#include <xenctrl.h>
+*/
+import "C"
+
+import (
+	"fmt"
+	"time"
+)
+
+type XcInterface struct {
+	xch *C.xc_interface
+}
+
+var Xch XcInterface
+
+func (Xch *XcInterface) IsOpen() bool {
+	return Xch.xch != nil
+}
+
+func (Xch *XcInterface) Open() (err error) {
+	if Xch.xch != nil {
+		return
+	}
+
+	Xch.xch = C.xc_interface_open(nil, nil, 0)
+	if Xch.xch == nil {
+		err = fmt.Errorf("Opening xc interface")
+	}
+	return
+}
+
+func (Xch *XcInterface) Close() {
+	if Xch.xch != nil {
+		C.xc_interface_close(Xch.xch)
+		Xch.xch = nil
+	}
+}
+
+// int xc_getcpuinfo(xc_interface *xch, int max_cpus,
+//                   xc_cpuinfo_t *info, int *nr_cpus);
+//
+// Total idle time of each pcpu, indexed by cpu
+func (Xch *XcInterface) CpuIdle(maxCpus int) (idle []time.Duration, err error) {
+	if Xch.xch == nil {
+		err = fmt.Errorf("xc interface not open")
+		return
+	}
+
+	info := make([]C.xc_cpuinfo_t, maxCpus)
+	var nrCpus C.int
+
+	ret := C.xc_getcpuinfo(Xch.xch, C.int(maxCpus), &info[0], &nrCpus)
+	if ret != 0 {
+		err = fmt.Errorf("xc_getcpuinfo failed: %d", ret)
+		return
+	}
+
+	for i := 0; i < int(nrCpus); i++ {
+		idle = append(idle, time.Duration(info[i].idletime))
+	}
+	return
+}
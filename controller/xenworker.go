@@ -25,6 +25,8 @@ import (
 	"encoding/json"
 	"bufio"
 	"io"
+	"sync"
+	"time"
 )
 
 type XenWorker struct {
@@ -33,8 +35,17 @@ type XenWorker struct {
 	domid int
 	consoleCmd *exec.Cmd
 	console io.ReadCloser
-	jsonStarted bool
+	proto ProtocolParser
 	Log []string
+	// Passed to the worker through xenstore; see writeConfig
+	rcfg RumpRunConfig
+	// Set once the domain is unpaused, by Process; Sample runs in
+	// another goroutine
+	runningLock sync.Mutex
+	running bool
+	// Estimated from the state of the vcpus at each sample
+	runstate Runstate
+	lastSample time.Time
 }
 
 // We have to capitalize the element names so that the json class can
@@ -57,6 +68,53 @@ func (w *XenWorker) SetId(i WorkerId) {
 	w.id = i
 	w.vmname = fmt.Sprintf("worker-%v", i)
 	w.domid = -1 // INVALID DOMID
+	w.proto.Stats.Id = i
+}
+
+// Neither libxl nor libxc give dom0 a vcpu's runstate times, only
+// what state it's in right now, so count the whole time since the
+// last sample as spent in that state, and mark the result an estimate.  Until
+// the domain is unpaused its vcpus look runnable, so start sampling
+// only after that.
+func (w *XenWorker) Sample(snap *CpuSnapshot) (s CpuSample, ok bool) {
+	w.runningLock.Lock()
+	running := w.running
+	w.runningLock.Unlock()
+	if !running {
+		return
+	}
+
+	now := time.Now()
+	s.Cputime, ok = snap.Domain(Domid(w.domid))
+	if !ok {
+		return
+	}
+
+	if !w.lastSample.IsZero() {
+		elapsed := now.Sub(w.lastSample)
+		for _, v := range snap.Vcpus(Domid(w.domid)) {
+			switch {
+			case !v.Online:
+				w.runstate.Offline += elapsed
+			case v.Running:
+				w.runstate.Running += elapsed
+			case v.Blocked:
+				w.runstate.Blocked += elapsed
+			default:
+				w.runstate.Runnable += elapsed
+			}
+		}
+	}
+	w.lastSample = now
+
+	s.Now = now.UnixNano()
+	s.Runstate = w.runstate
+	s.Estimated = true
+	return
+}
+
+func (w *XenWorker) Protocol() WorkerProtocol {
+	return w.proto.Stats
 }
 
 func (w *XenWorker) Init(p WorkerParams, g WorkerConfig) (err error) {
@@ -142,41 +200,19 @@ func (w *XenWorker) Init(p WorkerParams, g WorkerConfig) (err error) {
 	
 	// Set xenstore config
 	{
-		rcfg := RumpRunConfig{
+		w.rcfg = RumpRunConfig{
 			Blk:RumpRunConfigBlk{Source:"dev",
 				Path:"virtual",
 				Fstype:"kernfs",
 				Mountpoint:"/kern"},
 			Hostname:w.vmname}
 		
-		rcfg.Cmdline = "worker-xen.img"
+		w.rcfg.Cmdline = "worker-xen.img"
 		for _, a := range p.Args {
-			rcfg.Cmdline += fmt.Sprintf(" %s", a)
+			w.rcfg.Cmdline += fmt.Sprintf(" %s", a)
 		}
 
-		var rcfgBytes []byte
-	
-		rcfgBytes, err = json.Marshal(rcfg)
-		if err != nil {
-			fmt.Printf("Error marshalling rumprun json: %v\n", err)
-			return
-		}
-
-		//fmt.Printf("json:\n%s\n", string(rcfgBytes))
-		rcfgPath := fmt.Sprintf("/local/domain/%d/rumprun/cfg", w.domid)
-
-		//fmt.Printf("Writing to %s, json config %s\n", rcfgPath, rcfgBytes)
-		
-		args := []string{"xenstore-write", rcfgPath, string(rcfgBytes)}
-		if mock {
-			args = append([]string{"echo"}, args...)
-		}
-		e := exec.Command(args[0], args[1:]...)
-		
-		e.Stdout = os.Stdout
-		e.Stderr = os.Stderr
-
-		err = e.Run()
+		err = w.writeConfig()
 		if err != nil {
 			fmt.Printf("Error writing json into xenstore: %v\n", err)
 			return
@@ -204,6 +240,31 @@ func (w *XenWorker) Init(p WorkerParams, g WorkerConfig) (err error) {
 	return
 }
 
+func xenstore(args ...string) (err error) {
+	e := exec.Command(args[0], args[1:]...)
+
+	e.Stdout = os.Stdout
+	e.Stderr = os.Stderr
+
+	err = e.Run()
+	return
+}
+
+// rumprun reads its command line from here when the domain is
+// unpaused, so this can be rewritten until then
+func (w *XenWorker) writeConfig() (err error) {
+	var rcfgBytes []byte
+
+	rcfgBytes, err = json.Marshal(w.rcfg)
+	if err != nil {
+		return
+	}
+
+	rcfgPath := fmt.Sprintf("/local/domain/%d/rumprun/cfg", w.domid)
+	err = xenstore("xenstore-write", rcfgPath, string(rcfgBytes))
+	return
+}
+
 // FIXME: Return an error
 func (w *XenWorker) Shutdown() {
 	// xl destroy [vmname]
@@ -219,6 +280,42 @@ func (w *XenWorker) Shutdown() {
 	}
 }
 
+// Connect the two workers with an event channel.  Guests can't read
+// each other's xenstore directories, so make a node in the
+// responder's which it owns and the initiator can read; the responder
+// writes the port it allocated there, and the initiator binds to it.
+func (w *XenWorker) Pair(p Worker) (err error) {
+	peer, ok := p.(*XenWorker)
+	if !ok {
+		err = fmt.Errorf("Can't pair xen worker with %T", p)
+		return
+	}
+	if w.domid < 0 || peer.domid < 0 {
+		err = fmt.Errorf("Domain not created")
+		return
+	}
+
+	portPath := fmt.Sprintf("/local/domain/%d/data/schedbench-port", peer.domid)
+	err = xenstore("xenstore-write", portPath, "")
+	if err != nil {
+		return
+	}
+	err = xenstore("xenstore-chmod", portPath,
+		fmt.Sprintf("n%d", peer.domid), fmt.Sprintf("r%d", w.domid))
+	if err != nil {
+		return
+	}
+
+	w.rcfg.Cmdline += fmt.Sprintf(" xenpeer %d 1", peer.domid)
+	peer.rcfg.Cmdline += fmt.Sprintf(" xenpeer %d 0", w.domid)
+	err = w.writeConfig()
+	if err != nil {
+		return
+	}
+	err = peer.writeConfig()
+	return
+}
+
 func (w *XenWorker) DumpLog(f io.Writer) (err error) {
 	b := bufio.NewWriter(f)
 	defer b.Flush()
@@ -244,6 +341,9 @@ func (w *XenWorker) Process(report chan WorkerReport, done chan WorkerId) {
 		fmt.Printf("Error unpausing domain: %v\n", err)
 		return
 	}
+	w.runningLock.Lock()
+	w.running = true
+	w.runningLock.Unlock()
 
 	scanner := bufio.NewScanner(w.console)
 
@@ -254,9 +354,8 @@ func (w *XenWorker) Process(report chan WorkerReport, done chan WorkerId) {
 		
 		//fmt.Println("Got these bytes: ", s);
 
-		if w.jsonStarted {
-			var r WorkerReport
-			json.Unmarshal([]byte(s), &r)
+		r, ok := w.proto.Parse(s)
+		if ok {
 			r.Id = w.id
 			di, err := Ctx.DomainInfo(Domid(w.domid))
 			// Ignore errors for now
@@ -264,11 +363,6 @@ func (w *XenWorker) Process(report chan WorkerReport, done chan WorkerId) {
 				r.Cputime = di.Cpu_time
 			}
 			report <- r
-		} else {
-			if s == "START JSON" {
-				//fmt.Println("Got token to start parsing json")
-				w.jsonStarted = true
-			}
 		}
 	}
 
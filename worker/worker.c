@@ -33,6 +33,7 @@
 #include <sys/mman.h>
 #include <string.h>
 #include <strings.h>
+#include <unistd.h>
 
 #define USEC 1000
 #define MSEC 1000000
@@ -40,6 +41,12 @@
 
 #define PAGE_SIZE 4096
 
+// Controller <-> worker line protocol.  Every protocol line is
+//   SB [type] [seq] [crc32 of json, hex] [json]
+// The first is a "hello" (seq 0); after that, "report"s numbered from 1.
+#define PROTOCOL_VERSION 1
+#define PROTOCOL_CAPS "[\"burnwait\", \"pingpong\"]"
+
 static inline uint64_t rdtsc(void)
 {
     uint32_t low, high;
@@ -117,8 +124,152 @@ struct {
 } work = { 0 };
 
 
+// Ping-pong with a peer worker: rfd / wfd are set up by the
+// controller for process workers; Xen workers use an event channel
+// (see below).  The initiator sends a message, waits for the reply and
+// records the round-trip time; the responder waits for a message,
+// burns, and replies.  Both sides burn kops per message.
+struct {
+    int paired;
+    int rfd, wfd;
+    int domid;
+    int initiator;
+    uint64_t kops;
+    uint64_t msgs;
+
+    // Reporting
+    int64_t rtt_total;
+    int64_t rtt_min, rtt_max;
+} peer = { .rfd = -1, .wfd = -1, .domid = -1 };
+
+#ifdef WORKER_XEN
+// rumprun links us into the same image as mini-os, so we can use its
+// event channel and xenstore interfaces directly.  The controller
+// gives each side of the pair the other's domid.  The responder
+// allocates an unbound port for the initiator and writes it to
+// data/schedbench-port, which the controller has made readable by
+// the initiator; the initiator binds to it.  A message is then just
+// a notification, and the waiting side blocks its thread (and so,
+// with nothing else to run, its vcpu) until one arrives.
+typedef uint32_t evtchn_port_t;
+typedef uint16_t domid_t;
+struct pt_regs;
+typedef void (*evtchn_handler_t)(evtchn_port_t, struct pt_regs *, void *);
+int minios_evtchn_alloc_unbound(domid_t pal, evtchn_handler_t handler,
+                                void *data, evtchn_port_t *port);
+int minios_evtchn_bind_interdomain(domid_t pal, evtchn_port_t remote_port,
+                                   evtchn_handler_t handler, void *data,
+                                   evtchn_port_t *local_port);
+int minios_notify_remote_via_evtchn(evtchn_port_t port);
+char *xenbus_read(uint32_t xbt, const char *path, char **value);
+char *xenbus_write(uint32_t xbt, const char *path, const char *value);
+#define XBT_NIL 0
+
+struct bmk_thread;
+struct bmk_thread *bmk_sched_current(void);
+void bmk_sched_blockprepare(void);
+void bmk_sched_block(void);
+void bmk_sched_wake(struct bmk_thread *);
+
+struct {
+    evtchn_port_t port;
+    volatile unsigned pending;
+    struct bmk_thread *waiter;
+} xpeer;
+
+// Called from the event upcall, so just count and wake
+void xpeer_handler(evtchn_port_t port, struct pt_regs *regs, void *data) {
+    __sync_fetch_and_add(&xpeer.pending, 1);
+    if ( xpeer.waiter )
+        bmk_sched_wake(xpeer.waiter);
+}
+
+void xpeer_setup(void) {
+    char path[64], *val, *err;
+    int rc, i;
+
+    xpeer.waiter = bmk_sched_current();
+
+    if ( !peer.initiator ) {
+        rc = minios_evtchn_alloc_unbound(peer.domid, xpeer_handler, NULL,
+                                         &xpeer.port);
+        if ( rc ) {
+            fprintf(stderr, "pingpong: alloc_unbound failed (%d)\n", rc);
+            exit(1);
+        }
+        snprintf(path, sizeof(path), "%u", xpeer.port);
+        err = xenbus_write(XBT_NIL, "data/schedbench-port", path);
+        if ( err ) {
+            fprintf(stderr, "pingpong: writing port: %s\n", err);
+            exit(1);
+        }
+        return;
+    }
+
+    // Give the responder 10s to publish its port
+    snprintf(path, sizeof(path), "/local/domain/%d/data/schedbench-port",
+             peer.domid);
+    for ( i = 0; i < 10000; i++ ) {
+        val = NULL;
+        err = xenbus_read(XBT_NIL, path, &val);
+        if ( !err && val && *val ) {
+            evtchn_port_t remote = strtoul(val, NULL, 0);
+
+            free(val);
+            rc = minios_evtchn_bind_interdomain(peer.domid, remote,
+                                                xpeer_handler, NULL,
+                                                &xpeer.port);
+            if ( rc ) {
+                fprintf(stderr, "pingpong: bind_interdomain failed (%d)\n", rc);
+                exit(1);
+            }
+            return;
+        }
+        free(err);
+        free(val);
+        nsleep(MSEC);
+    }
+    fprintf(stderr, "pingpong: peer %d never published its port\n", peer.domid);
+    exit(1);
+}
+
+void xpeer_wait(void) {
+    while ( !xpeer.pending ) {
+        bmk_sched_blockprepare();
+        if ( xpeer.pending )
+            bmk_sched_wake(xpeer.waiter);
+        bmk_sched_block();
+    }
+    __sync_fetch_and_sub(&xpeer.pending, 1);
+}
+#endif
+
 struct queue_elem *eventqueue = NULL;
 
+uint64_t report_seq = 0;
+
+// Standard (IEEE) CRC-32, as in Go's hash/crc32
+uint32_t crc32(const char *buf, size_t len) {
+    uint32_t crc = 0xffffffff;
+    size_t i;
+    int j;
+
+    for ( i = 0; i < len; i++ ) {
+        crc ^= (unsigned char)buf[i];
+        for ( j = 0; j < 8; j++ )
+            crc = (crc >> 1) ^ (0xedb88320 & -(crc & 1));
+    }
+
+    return ~crc;
+}
+
+void send_line(const char *type, const char *json) {
+    printf("SB %s %llu %08x %s\n", type, report_seq,
+           crc32(json, strlen(json)), json);
+    fflush(stdout);
+    report_seq++;
+}
+
 int eventqueue_insert(struct work_desc wd, uint64_t timer) {
     struct queue_elem *eq, **p;
 
@@ -172,11 +323,22 @@ void report(int64_t n) {
     if ( (work.next_report == 0)
          || n > work.next_report ) {
 
-        printf("{ \"Now\":%lld, \"Kops\":%llu, \"MaxDelta\":%llu }\n",
-               n, work.kops_done, work.queue_max_delta);
-        fflush(stdout);
+        char buf[256];
+
+        if ( !peer.paired ) {
+            snprintf(buf, sizeof(buf),
+                     "{ \"Now\":%lld, \"Kops\":%llu, \"MaxDelta\":%llu }",
+                     n, work.kops_done, work.queue_max_delta);
+        } else {
+            snprintf(buf, sizeof(buf),
+                     "{ \"Now\":%lld, \"Kops\":%llu, \"MaxDelta\":%llu, \"Msgs\":%llu, \"RttTotal\":%lld, \"RttMin\":%lld, \"RttMax\":%lld }",
+                     n, work.kops_done, work.queue_max_delta,
+                     peer.msgs, peer.rtt_total, peer.rtt_min, peer.rtt_max);
+        }
+        send_line("report", buf);
 
         work.queue_max_delta = 0;
+        peer.rtt_min = peer.rtt_max = 0;
 
         if (!work.next_report) 
             work.next_report = n;
@@ -200,23 +362,101 @@ void worker_setup(void) {
 
 }
 
-void process_worker(struct work_desc wd) {
+void burn(uint64_t kops) {
     int i;
     
     // Write sequentially to data for mops operations
-    for ( i=0; i < wd.kops * 1000 ; i++) {
+    for ( i=0; i < kops * 1000 ; i++) {
         work.index++;
         if (work.index > work.size / sizeof(int))
             work.index -= work.size / sizeof(int);
         (*((volatile int *)work.data+work.index)) &= work.counter++;
     }
-    work.kops_done += wd.kops;
+    work.kops_done += kops;
+}
+
+void process_worker(struct work_desc wd) {
+    burn(wd.kops);
     
     eventqueue_insert(wd, wd.wait_nsec);
 }
 
+void peer_read(uint64_t *seq) {
+    ssize_t rc;
+
+#ifdef WORKER_XEN
+    if ( peer.domid >= 0 ) {
+        xpeer_wait();
+        return;
+    }
+#endif
+    rc = read(peer.rfd, seq, sizeof(*seq));
+
+    if ( rc != sizeof(*seq) ) {
+        fprintf(stderr, "pingpong: peer read failed (%zd)\n", rc);
+        exit(1);
+    }
+}
+
+void peer_write(uint64_t seq) {
+    ssize_t rc;
+
+#ifdef WORKER_XEN
+    if ( peer.domid >= 0 ) {
+        minios_notify_remote_via_evtchn(xpeer.port);
+        return;
+    }
+#endif
+    rc = write(peer.wfd, &seq, sizeof(seq));
+
+    if ( rc != sizeof(seq) ) {
+        fprintf(stderr, "pingpong: peer write failed (%zd)\n", rc);
+        exit(1);
+    }
+}
+
+void pingpong_loop(void) {
+    uint64_t seq = 0;
+
+#ifdef WORKER_XEN
+    if ( peer.domid >= 0 )
+        xpeer_setup();
+#endif
+
+    while(1) {
+        int64_t n = now();
+
+        report(n);
+
+        if ( peer.initiator ) {
+            int64_t rtt;
+
+            peer_write(seq);
+            peer_read(&seq);
+
+            rtt = now() - n;
+            peer.rtt_total += rtt;
+            if ( rtt > peer.rtt_max )
+                peer.rtt_max = rtt;
+            if ( rtt < peer.rtt_min || peer.rtt_min == 0 )
+                peer.rtt_min = rtt;
+
+            burn(peer.kops);
+            seq++;
+        } else {
+            peer_read(&seq);
+            burn(peer.kops);
+            peer_write(seq);
+        }
+        peer.msgs++;
+    }
+}
+
 /* report_interval [report_ms]
-   burnwait [kops] [wait_nsec] */
+   burnwait [kops] [wait_nsec]
+   pingpong [kops]
+   peer [rfd] [wfd] [initiator]
+   xenpeer [domid] [initiator] */
 int main(int argc, char *argv[]) {
 
     init_clock();
@@ -263,6 +503,32 @@ int main(int argc, char *argv[]) {
             wd.wait_nsec=strtoul(argv[i], NULL, 0);
 
             eventqueue_insert(wd, 0);
+        } else if (!strcmp(argv[i], "pingpong")) {
+            i++;
+            if(!(i<argc)) {
+                fprintf(stderr, "Not enough aguments for pingpong");
+                exit(1);
+            }
+            peer.kops=strtoul(argv[i], NULL, 0);
+        } else if (!strcmp(argv[i], "peer")) {
+            if(!(i+3<argc)) {
+                fprintf(stderr, "Not enough aguments for peer");
+                exit(1);
+            }
+            peer.rfd=strtol(argv[++i], NULL, 0);
+            peer.wfd=strtol(argv[++i], NULL, 0);
+            peer.initiator=strtol(argv[++i], NULL, 0);
+            peer.paired = 1;
+#ifdef WORKER_XEN
+        } else if (!strcmp(argv[i], "xenpeer")) {
+            if(!(i+2<argc)) {
+                fprintf(stderr, "Not enough aguments for xenpeer");
+                exit(1);
+            }
+            peer.domid=strtol(argv[++i], NULL, 0);
+            peer.initiator=strtol(argv[++i], NULL, 0);
+            peer.paired = 1;
+#endif
         } else {
             fprintf(stderr, "Unknown toplevel command: %s\n", argv[i]);
             exit(1);
@@ -274,12 +540,31 @@ int main(int argc, char *argv[]) {
         while(1);
     }
     
+    if(peer.kops && !peer.paired) {
+        fprintf(stderr, "pingpong needs a peer!\n");
+        exit(1);
+    }
+
+    if(peer.paired && eventqueue) {
+        fprintf(stderr, "Can't mix pingpong and burnwait\n");
+        exit(1);
+    }
+    
     worker_setup();
     
-    fflush(stdout);
-    printf("START JSON\n");
-    fflush(stdout);
+    {
+        char buf[256];
+
+        snprintf(buf, sizeof(buf),
+                 "{ \"Version\":%d, \"Caps\":%s, \"kHZ\":%llu }",
+                 PROTOCOL_VERSION, PROTOCOL_CAPS, kHZ);
+        fflush(stdout);
+        send_line("hello", buf);
+    }
 
-    eventqueue_loop();
+    if(peer.paired)
+        pingpong_loop();
+    else
+        eventqueue_loop();
 
 }